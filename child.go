@@ -0,0 +1,123 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// forwardSignals are the signals passed on to a child
+// process, and that interrupt sandbox preparation.
+var forwardSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT}
+
+var (
+	cleanupMu   sync.Mutex
+	cleanupDirs = make(map[string]bool)
+)
+
+// addCleanup registers dir, which holds partial work, to be
+// removed if deppy is interrupted.
+func addCleanup(dir string) {
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+	cleanupDirs[dir] = true
+}
+
+func removeCleanup(dir string) {
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+	delete(cleanupDirs, dir)
+}
+
+// catchInterrupts arranges for the directories registered
+// with addCleanup to be removed, and for deppy to exit, if
+// one of forwardSignals arrives. Calling the returned
+// function restores the default behavior.
+func catchInterrupts() (stop func()) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, forwardSignals...)
+	done := make(chan bool)
+	go func() {
+		select {
+		case sig := <-c:
+			cleanupMu.Lock()
+			for dir := range cleanupDirs {
+				os.RemoveAll(dir)
+			}
+			os.Exit(signalStatus(sig))
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(c)
+		close(done)
+	}
+}
+
+// runChild runs c, forwarding signals to it. If c fails,
+// runChild exits with c's exit status.
+func runChild(c *exec.Cmd) {
+	status, err := runForwarding(c)
+	if err != nil {
+		log.Fatalln(c.Args[0], err)
+	}
+	if status != 0 {
+		os.Exit(status)
+	}
+}
+
+// runForwarding runs c, forwarding forwardSignals to it, and
+// returns its exit status. A child killed by a signal has
+// status 128 plus the signal number, as in the shell.
+//
+// The child is in deppy's process group, so SIGINT and SIGQUIT
+// typed at the terminal reach it directly; those are not sent
+// again, or the child would see each one twice. The same
+// signals sent to deppy alone, as with kill, are not passed on.
+func runForwarding(c *exec.Cmd) (status int, err error) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, forwardSignals...)
+	defer signal.Stop(sigs)
+	start := time.Now()
+	if err := c.Start(); err != nil {
+		return 0, err
+	}
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-sigs:
+				if sig != os.Interrupt && sig != syscall.SIGQUIT {
+					c.Process.Signal(sig)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	err = c.Wait()
+	traceCmd(c, start, err)
+	if e, ok := err.(*exec.ExitError); ok {
+		if ws, ok := e.Sys().(syscall.WaitStatus); ok {
+			if ws.Signaled() {
+				return signalStatus(ws.Signal()), nil
+			}
+			return ws.ExitStatus(), nil
+		}
+	}
+	return 0, err
+}
+
+// signalStatus returns the exit status for a process
+// terminated by sig.
+func signalStatus(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 1
+}
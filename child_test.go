@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunForwarding(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not installed")
+	}
+	var cases = []struct {
+		script string
+		want   int
+	}{
+		{"exit 0", 0},
+		{"exit 3", 3},
+		{"kill -TERM $$", 128 + 15},
+	}
+	for _, test := range cases {
+		g, err := runForwarding(exec.Command("sh", "-c", test.script))
+		if err != nil {
+			t.Errorf("%q: %v", test.script, err)
+		}
+		if g != test.want {
+			t.Errorf("%q: status = %d want %d", test.script, g, test.want)
+		}
+	}
+	if _, err := runForwarding(exec.Command("deppy-no-such-command")); err == nil {
+		t.Error("missing command: no error")
+	}
+}
+
+// TestRunForwardingSignals checks that SIGTERM sent to deppy
+// alone reaches the child, while SIGINT, which the terminal
+// sends to the child too, is not sent again.
+func TestRunForwardingSignals(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not installed")
+	}
+	var cases = []struct {
+		sig  os.Signal
+		want int
+	}{
+		{syscall.SIGTERM, 128 + 15},
+		{os.Interrupt, 0},
+	}
+	self, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range cases {
+		go func(sig os.Signal) {
+			time.Sleep(200 * time.Millisecond)
+			self.Signal(sig)
+		}(test.sig)
+		g, err := runForwarding(exec.Command("sh", "-c", "sleep 1"))
+		if err != nil {
+			t.Errorf("%v: %v", test.sig, err)
+		}
+		if g != test.want {
+			t.Errorf("%v: status = %d want %d", test.sig, g, test.want)
+		}
+	}
+}
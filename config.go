@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Config holds settings that are not part of file Deps.
+// It is read from file .deppy in the user's home directory
+// and from file .deppy next to the project's Deps file.
+// Settings in the project file take precedence.
+type Config struct {
+	// Spool is the writable directory where deppy keeps
+	// repos and checked-out revisions.
+	Spool string `json:",omitempty"`
+
+	// ReadOnlySpools are spool directories, for example a
+	// prewarmed cache, that are consulted for checked-out
+	// revisions before anything is fetched into Spool.
+	ReadOnlySpools []string `json:",omitempty"`
+
+	// Rewrite lists rules for rewriting remote URLs,
+	// like git's url.<base>.insteadOf.
+	Rewrite []Rewrite `json:",omitempty"`
+
+	// Mirrors maps repo root import paths to fallback
+	// remote URLs, tried in order after the main remote.
+	// A key also applies to repos below it, with the rest
+	// of the repo root appended to each URL.
+	Mirrors map[string][]string `json:",omitempty"`
+
+	// Resolve lists repos whose location is known without
+	// a network lookup. Entries in file Deps come first.
+	Resolve []Resolution `json:",omitempty"`
+
+	// Sparse lists patterns, as in 'go help packages', of
+	// repo roots to check out sparsely. Only the directories
+	// of the listed packages and their imports from the same
+	// repo are populated in the sandbox.
+	Sparse []string `json:",omitempty"`
+}
+
+// A Rewrite rule replaces the prefix InsteadOf of a
+// remote URL with URL.
+type Rewrite struct {
+	URL       string
+	InsteadOf string
+}
+
+var config Config
+
+// loadConfig reads the user and project config files,
+// if present, and applies the DEPPY_* environment
+// variables on top of them.
+func loadConfig() error {
+	var files []string
+	home := os.Getenv("HOME")
+	if home != "" {
+		files = append(files, filepath.Join(home, ".deppy"))
+	}
+	if dir := findDeps(); dir != "" && dir != home {
+		files = append(files, filepath.Join(dir, ".deppy"))
+	}
+	for _, name := range files {
+		var c Config
+		err := readConfig(name, &c)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		config.merge(&c)
+	}
+	if s := os.Getenv("DEPPY_SPOOL"); s != "" {
+		config.Spool = s
+	}
+	if s := os.Getenv("DEPPY_READONLY_SPOOL"); s != "" {
+		config.ReadOnlySpools = filepath.SplitList(s)
+	}
+	if config.Spool != "" {
+		spool = config.Spool
+	}
+	return nil
+}
+
+func readConfig(name string, c *Config) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewDecoder(f).Decode(c)
+}
+
+// merge overlays the settings in o onto c.
+// Lists in o are consulted before the existing ones.
+func (c *Config) merge(o *Config) {
+	if o.Spool != "" {
+		c.Spool = o.Spool
+	}
+	c.ReadOnlySpools = append(o.ReadOnlySpools, c.ReadOnlySpools...)
+	c.Rewrite = append(o.Rewrite, c.Rewrite...)
+	c.Resolve = append(o.Resolve, c.Resolve...)
+	c.Sparse = append(o.Sparse, c.Sparse...)
+	for root, urls := range o.Mirrors {
+		if c.Mirrors == nil {
+			c.Mirrors = make(map[string][]string)
+		}
+		c.Mirrors[root] = urls
+	}
+}
+
+// rewriteURL applies the rewrite rule with the longest
+// matching prefix to url. If several rules have the same
+// prefix, the first one wins.
+func (c *Config) rewriteURL(url string) string {
+	var best *Rewrite
+	for i, r := range c.Rewrite {
+		if r.InsteadOf == "" || !strings.HasPrefix(url, r.InsteadOf) {
+			continue
+		}
+		if best == nil || len(r.InsteadOf) > len(best.InsteadOf) {
+			best = &c.Rewrite[i]
+		}
+	}
+	if best == nil {
+		return url
+	}
+	return best.URL + url[len(best.InsteadOf):]
+}
+
+// sparse reports whether the repo at import path root
+// should be checked out sparsely.
+func (c *Config) sparse(root string) bool {
+	for _, pat := range c.Sparse {
+		if matchPattern(pat)(root) {
+			return true
+		}
+	}
+	return false
+}
+
+// mirrors returns the fallback URLs for the repo at
+// import path root, using the longest matching key.
+func (c *Config) mirrors(root string) (a []string) {
+	var key string
+	var urls []string
+	for k, v := range c.Mirrors {
+		if containsPathPrefix([]string{k}, root) && len(k) > len(key) {
+			key, urls = k, v
+		}
+	}
+	for _, url := range urls {
+		a = append(a, url+root[len(key):])
+	}
+	return a
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestRewriteURL(t *testing.T) {
+	c := &Config{
+		Rewrite: []Rewrite{
+			{URL: "https://mirror.example.com/", InsteadOf: "https://"},
+			{URL: "https://git.example.com/gh/", InsteadOf: "https://github.com/"},
+			{URL: "https://other.example.com/gh/", InsteadOf: "https://github.com/"},
+		},
+	}
+	var cases = []struct {
+		url  string
+		want string
+	}{
+		{"https://github.com/kr/s3", "https://git.example.com/gh/kr/s3"},
+		{"https://code.google.com/p/x", "https://mirror.example.com/code.google.com/p/x"},
+		{"git://github.com/kr/s3", "git://github.com/kr/s3"},
+	}
+	for _, test := range cases {
+		g := c.rewriteURL(test.url)
+		if g != test.want {
+			t.Errorf("rewriteURL(%q) = %q want %q", test.url, g, test.want)
+		}
+	}
+}
+
+func TestMirrors(t *testing.T) {
+	c := &Config{
+		Mirrors: map[string][]string{
+			"github.com/kr":    {"a", "b"},
+			"github.com/kr/s3": {"c"},
+		},
+	}
+	var cases = []struct {
+		root string
+		want []string
+	}{
+		{"github.com/kr/s3", []string{"c"}},
+		{"github.com/kr/pty", []string{"a/pty", "b/pty"}},
+		{"github.com/kr", []string{"a", "b"}},
+		{"github.com/krx/pty", nil},
+	}
+	for _, test := range cases {
+		g := c.mirrors(test.root)
+		if !reflect.DeepEqual(g, test.want) {
+			t.Errorf("mirrors(%q) = %v want %v", test.root, g, test.want)
+		}
+	}
+}
+
+func TestConfigMerge(t *testing.T) {
+	c := &Config{
+		Spool:          "user",
+		ReadOnlySpools: []string{"u"},
+		Mirrors:        map[string][]string{"a": {"u"}, "b": {"u"}},
+	}
+	c.merge(&Config{
+		ReadOnlySpools: []string{"p"},
+		Mirrors:        map[string][]string{"a": {"p"}},
+	})
+	want := &Config{
+		Spool:          "user",
+		ReadOnlySpools: []string{"p", "u"},
+		Mirrors:        map[string][]string{"a": {"p"}, "b": {"u"}},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("merge = %+v want %+v", c, want)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "deppyconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	for _, name := range []string{"HOME", "DEPPY_SPOOL", "DEPPY_READONLY_SPOOL"} {
+		defer os.Setenv(name, os.Getenv(name))
+	}
+	defer func(c Config, s string) { config, spool = c, s }(config, spool)
+
+	home := filepath.Join(tmp, "home")
+	project := filepath.Join(tmp, "project")
+	writeFile(filepath.Join(project, "Deps"), "{}")
+	writeFile(filepath.Join(project, "sub", "x.go"), "package sub")
+	os.Setenv("HOME", home)
+	if err := os.Chdir(filepath.Join(project, "sub")); err != nil {
+		t.Fatal(err)
+	}
+
+	var cases = []struct {
+		user, project string // config file contents; "" for none
+		spool, ro     string // environment
+		wantSpool     string
+		wantRO        []string
+	}{
+		{"", "", "", "", "default", nil},
+		{`{"Spool": "u", "ReadOnlySpools": ["ur"]}`, "", "", "", "u", []string{"ur"}},
+		{"", `{"Spool": "p", "ReadOnlySpools": ["pr"]}`, "", "", "p", []string{"pr"}},
+		{`{"Spool": "u", "ReadOnlySpools": ["ur"]}`, `{"Spool": "p", "ReadOnlySpools": ["pr"]}`, "", "", "p", []string{"pr", "ur"}},
+		{`{"Spool": "u", "ReadOnlySpools": ["ur"]}`, `{"ReadOnlySpools": ["pr"]}`, "", "", "u", []string{"pr", "ur"}},
+		{`{"Spool": "u"}`, `{"Spool": "p", "ReadOnlySpools": ["pr"]}`, "e", "e1" + string(filepath.ListSeparator) + "e2", "e", []string{"e1", "e2"}},
+	}
+	for i, test := range cases {
+		for _, f := range []struct{ dir, body string }{{home, test.user}, {project, test.project}} {
+			name := filepath.Join(f.dir, ".deppy")
+			os.Remove(name)
+			if f.body != "" {
+				writeFile(name, f.body)
+			}
+		}
+		os.Setenv("DEPPY_SPOOL", test.spool)
+		os.Setenv("DEPPY_READONLY_SPOOL", test.ro)
+		config, spool = Config{}, "default"
+		if err := loadConfig(); err != nil {
+			t.Errorf("case %d: loadConfig: %v", i, err)
+			continue
+		}
+		if spool != test.wantSpool || !reflect.DeepEqual(config.ReadOnlySpools, test.wantRO) {
+			t.Errorf("case %d: spool %q, read-only %v; want %q, %v", i, spool, config.ReadOnlySpools, test.wantSpool, test.wantRO)
+		}
+	}
+}
+
+func TestSandboxReadOnlySpool(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "deppyrospool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	defer func(c Config, s string) { config, spool = c, s }(config, spool)
+	spool = filepath.Join(tmp, "spool")
+	config = Config{ReadOnlySpools: []string{filepath.Join(tmp, "ro1"), filepath.Join(tmp, "ro2")}}
+	writeFile(filepath.Join(tmp, "ro1", "rev", "ab", "cd", "src", "example.com", "r", "p.go"), "package r")
+	writeFile(filepath.Join(tmp, "ro2", "rev", "ab", "cd", "src", "example.com", "r", "p.go"), "package r")
+	writeFile(filepath.Join(tmp, "ro2", "rev", "ab", "ce", "src", "example.com", "r", "p.go"), "package r")
+	writeFile(filepath.Join(tmp, "spool", "rev", "ab", "cf", "src", "example.com", "r", "p.go"), "package r")
+
+	var cases = []struct {
+		rev  string
+		want string // relative to tmp; "" for an error
+	}{
+		{"abcd", "ro1/rev/ab/cd"},
+		{"abce", "ro2/rev/ab/ce"},
+		{"abcf", "spool/rev/ab/cf"},
+		{"abcg", ""}, // would need a fetch, but there is no repo
+	}
+	for _, test := range cases {
+		// With no vcs, anything but a checkout on disk fails.
+		d := Dependency{ImportPath: "example.com/r", Rev: test.rev, repoRoot: &vcs.RepoRoot{Root: "example.com/r"}}
+		gopath, err := sandbox(d)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("sandbox(%s) = %s, want error", test.rev, gopath)
+			}
+			continue
+		}
+		if want := filepath.Join(tmp, filepath.FromSlash(test.want)); err != nil || gopath != want {
+			t.Errorf("sandbox(%s) = %s, %v want %s", test.rev, gopath, err, want)
+		}
+	}
+}
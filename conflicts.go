@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var cmdConflicts = &Command{
+	Usage: "conflicts",
+	Short: "compare Deps with the Deps files of dependencies",
+	Long: `
+Conflicts reads the dependency list, if any, at the root of each
+dependency's repo, at the revision given in file Deps, and reports
+every repo it pins at a revision different from ours. Both Deps and
+Godeps/Godeps.json files are read.
+
+It exits with status 1 if there are conflicts.
+`,
+	Run: runConflicts,
+}
+
+// manifestNames are the files, relative to a repo root,
+// holding a dependency list in the format of file Deps.
+var manifestNames = []string{"Deps", filepath.Join("Godeps", "Godeps.json")}
+
+// isManifestName reports whether name, a slash-separated path
+// relative to a repo root, is one of manifestNames.
+func isManifestName(name string) bool {
+	return containsString(manifestNames, filepath.FromSlash(name))
+}
+
+// A manifest is the dependency list of one of our
+// dependencies.
+type manifest struct {
+	Root string // import path of the repo root
+	Name string // file name, relative to the repo root
+	Deps []Dependency
+}
+
+// A conflict is a pin in a manifest that disagrees with ours.
+type conflict struct {
+	Root string // import path of the repo root
+	Rev  string // our revision
+	By   *manifest
+	Want string
+}
+
+func runConflicts(cmd *Command, args []string) {
+	if len(args) != 0 {
+		cmd.UsageExit()
+	}
+	g, err := ReadAndLoadDeps(findDepsJSON())
+	if err != nil {
+		log.Fatalln(err)
+	}
+	gopaths, err := sandboxDeps(g.Deps)
+	if err != nil {
+		fatal(err)
+	}
+	pins := make(map[string]string)
+	dirs := make(map[string]string)
+	for i, d := range g.Deps {
+		pins[d.repoRoot.Root] = d.Rev
+		dirs[d.repoRoot.Root] = filepath.Join(gopaths[i], "src", filepath.FromSlash(d.repoRoot.Root))
+	}
+	ms, err := readManifests(dirs)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if c := findConflicts(pins, ms); len(c) > 0 {
+		printConflicts(os.Stdout, c)
+		os.Exit(1)
+	}
+}
+
+// readManifests reads the manifest, if any, of each repo in
+// dirs, a map from repo root import path to directory.
+func readManifests(dirs map[string]string) (a []*manifest, err error) {
+	for root, dir := range dirs {
+		m, err := readManifest(root, dir)
+		if err != nil {
+			return nil, err
+		}
+		if m != nil {
+			a = append(a, m)
+		}
+	}
+	return a, nil
+}
+
+// readManifest reads the first of manifestNames present in
+// dir. It returns nil if there is none.
+func readManifest(root, dir string) (*manifest, error) {
+	for _, name := range manifestNames {
+		path := filepath.Join(dir, name)
+		if fi, err := os.Stat(path); err != nil || fi.IsDir() {
+			continue
+		}
+		var g Deps
+		if err := ReadDeps(path, &g); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return &manifest{Root: root, Name: filepath.ToSlash(name), Deps: g.Deps}, nil
+	}
+	return nil, nil
+}
+
+// findConflicts returns the entries in ms for repos in pins,
+// a map from repo root import path to our revision, whose
+// revision is not ours. Entries for a manifest's own repo
+// are ignored. The result is sorted by repo root.
+func findConflicts(pins map[string]string, ms []*manifest) (a []conflict) {
+	for _, m := range ms {
+		seen := make(map[string]bool)
+		for _, d := range m.Deps {
+			root := pinnedRoot(pins, d.ImportPath)
+			if root == "" || root == m.Root || seen[root] {
+				continue
+			}
+			seen[root] = true
+			if d.Rev != pins[root] {
+				a = append(a, conflict{root, pins[root], m, d.Rev})
+			}
+		}
+	}
+	sort.Sort(byRoot(a))
+	return a
+}
+
+// pinnedRoot returns the longest repo root in pins
+// containing importPath, or "" if there is none.
+func pinnedRoot(pins map[string]string, importPath string) (root string) {
+	for r := range pins {
+		if (importPath == r || strings.HasPrefix(importPath, r+"/")) && len(r) > len(root) {
+			root = r
+		}
+	}
+	return root
+}
+
+// printConflicts writes a, sorted by repo root, to w.
+// With flag -json, it emits an Event for each instead.
+func printConflicts(w io.Writer, a []conflict) {
+	if jsonOutput {
+		for _, c := range a {
+			emit(Event{Action: "conflict", Root: c.Root, Rev: c.Rev, By: c.By.Root, Manifest: c.By.Name, Want: c.Want})
+		}
+		return
+	}
+	for i, c := range a {
+		if i == 0 || a[i-1].Root != c.Root {
+			fmt.Fprintf(w, "%s: Deps has %s\n", c.Root, c.Rev)
+		}
+		fmt.Fprintf(w, "\t%s (%s) wants %s\n", c.By.Root, c.By.Name, c.Want)
+	}
+}
+
+type byRoot []conflict
+
+func (a byRoot) Len() int      { return len(a) }
+func (a byRoot) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byRoot) Less(i, j int) bool {
+	if a[i].Root != a[j].Root {
+		return a[i].Root < a[j].Root
+	}
+	return a[i].By.Root < a[j].By.Root
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindConflicts(t *testing.T) {
+	pins := map[string]string{
+		"example.com/a":     "a1",
+		"example.com/b":     "b1",
+		"example.com/b/sub": "s1",
+		"example.com/c":     "c1",
+	}
+	x := &manifest{Root: "example.com/x", Name: "Deps", Deps: []Dependency{
+		{ImportPath: "example.com/a/pkg", Rev: "a2"},
+		{ImportPath: "example.com/a/other", Rev: "a2"}, // same repo, reported once
+		{ImportPath: "example.com/b", Rev: "b1"},
+		{ImportPath: "example.com/b/sub/pkg", Rev: "s2"},
+		{ImportPath: "example.com/unused", Rev: "u1"},
+	}}
+	c := &manifest{Root: "example.com/c", Name: "Godeps/Godeps.json", Deps: []Dependency{
+		{ImportPath: "example.com/a", Rev: "a3"},
+		{ImportPath: "example.com/c/vendored", Rev: "c2"}, // own repo
+	}}
+	got := findConflicts(pins, []*manifest{x, c})
+	want := []conflict{
+		{"example.com/a", "a1", c, "a3"},
+		{"example.com/a", "a1", x, "a2"},
+		{"example.com/b/sub", "s1", x, "s2"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("findConflicts = %+v want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("conflict %d = %+v want %+v", i, got[i], want[i])
+		}
+	}
+
+	var buf bytes.Buffer
+	printConflicts(&buf, got)
+	wantOut := "example.com/a: Deps has a1\n" +
+		"\texample.com/c (Godeps/Godeps.json) wants a3\n" +
+		"\texample.com/x (Deps) wants a2\n" +
+		"example.com/b/sub: Deps has s1\n" +
+		"\texample.com/x (Deps) wants s2\n"
+	if g := buf.String(); g != wantOut {
+		t.Errorf("printConflicts = %q want %q", g, wantOut)
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deppyconflicts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	godeps := `{"ImportPath": "example.com/g", "Deps": [{"ImportPath": "example.com/a", "Rev": "a1"}]}`
+	if err := writeFile(filepath.Join(dir, "g", "Godeps", "Godeps.json"), godeps); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "n", "Deps"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	m, err := readManifest("example.com/g", filepath.Join(dir, "g"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil || m.Name != "Godeps/Godeps.json" || len(m.Deps) != 1 || m.Deps[0].Rev != "a1" {
+		t.Errorf("readManifest = %+v", m)
+	}
+	m, err = readManifest("example.com/n", filepath.Join(dir, "n"))
+	if m != nil || err != nil {
+		t.Errorf("readManifest = %+v, %v want nil, nil", m, err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
@@ -21,7 +22,8 @@ import (
 type Deps struct {
 	ImportPath string
 	GoVersion  string
-	Packages   []string `json:",omitempty"` // Arguments to save, if any.
+	Packages   []string     `json:",omitempty"` // Arguments to save, if any.
+	Resolve    []Resolution `json:",omitempty"` // Maintained by hand.
 	Deps       []Dependency
 
 	outerRoot string
@@ -43,9 +45,11 @@ type Dependency struct {
 	pkg     *Package
 
 	// used by command go
-	outerRoot string // dir, if present, in outer GOPATH
-	repoRoot  *vcs.RepoRoot
-	vcs       *VCS
+	outerRoot  string // dir, if present, in outer GOPATH
+	repoRoot   *vcs.RepoRoot
+	static     bool // repoRoot came from a Resolve table
+	vcs        VCS
+	sparseDirs []string // dirs to check out, if sparse
 }
 
 // Load expects pkgs to be the list of packages to read dependencies
@@ -54,7 +58,7 @@ func (g *Deps) Load(pkgs []*Package) error {
 	var path, seen []string
 	for _, p := range pkgs {
 		if p.Standard {
-			log.Println("ignoring stdlib package:", p.ImportPath)
+			warn("ignoring stdlib package:", p.ImportPath)
 			continue
 		}
 		if p.Error.Err != "" {
@@ -152,6 +156,7 @@ func ReadDeps(path string, g *Deps) error {
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 	return json.NewDecoder(f).Decode(g)
 }
 
@@ -174,6 +179,11 @@ func eqDeps(a, b []Dependency) bool {
 	return ok
 }
 
+// resolveP is the number of dependencies ReadAndLoadDeps
+// resolves at a time. Resolving is mostly waiting on the
+// network, so this doesn't depend on the number of CPUs.
+const resolveP = 8
+
 // ReadAndLoadDeps populates a *Deps from a file path, which
 // is presumably a Deps file
 func ReadAndLoadDeps(path string) (*Deps, error) {
@@ -187,16 +197,76 @@ func ReadAndLoadDeps(path string) (*Deps, error) {
 		return nil, err
 	}
 
-	for i := range g.Deps {
-		d := &g.Deps[i]
-		d.vcs, d.repoRoot, err = VCSForImportPath(d.ImportPath)
+	// Resolving may take a network round trip per
+	// dependency, so do several at once.
+	table := g.resolveTable()
+	errs := parallel(len(g.Deps), resolveP, func(i int) error {
+		return g.Deps[i].resolve(table)
+	})
+	var missing offlineError
+	for _, err := range errs {
+		if err != nil && offline {
+			missing = append(missing, err.Error())
+			continue
+		}
 		if err != nil {
 			return nil, err
 		}
 	}
+	if missing != nil {
+		return nil, missing
+	}
 	return g, nil
 }
 
+// resolve finds the VCS and repo root of d, looking first
+// in the static resolution table.
+func (d *Dependency) resolve(table []Resolution) (err error) {
+	d.vcs, d.repoRoot, err = resolveStatic(d.ImportPath, table)
+	if err != nil || d.repoRoot != nil {
+		d.static = d.repoRoot != nil
+		return err
+	}
+	if offline {
+		d.vcs, d.repoRoot, err = d.resolveOffline()
+		return err
+	}
+	d.vcs, d.repoRoot, err = VCSForImportPath(d.ImportPath)
+	return err
+}
+
+// pin sets the revision of every entry in d's repo to that
+// of d, adding an entry for d, in order, if there is none.
+func (g *Deps) pin(d Dependency) {
+	found := false
+	for i := range g.Deps {
+		e := &g.Deps[i]
+		if e.ImportPath == d.ImportPath {
+			found = true
+		}
+		if e.ImportPath == d.repoRoot.Root || strings.HasPrefix(e.ImportPath, d.repoRoot.Root+"/") {
+			e.Rev, e.Comment = d.Rev, d.Comment
+		}
+	}
+	if found {
+		return
+	}
+	i := 0
+	for i < len(g.Deps) && g.Deps[i].ImportPath < d.ImportPath {
+		i++
+	}
+	e := Dependency{ImportPath: d.ImportPath, Comment: d.Comment, Rev: d.Rev}
+	g.Deps = append(g.Deps[:i], append([]Dependency{e}, g.Deps[i:]...)...)
+}
+
+// resolveTable returns the static resolution entries from
+// g followed by those from the config files.
+func (g *Deps) resolveTable() []Resolution {
+	var a []Resolution
+	a = append(a, g.Resolve...)
+	return append(a, config.Resolve...)
+}
+
 func (g *Deps) loadGoList() error {
 	a := []string{g.ImportPath}
 	for _, d := range g.Deps {
@@ -234,9 +304,29 @@ func (d Dependency) RepoPath() string {
 	return filepath.Join(spool, "repo", d.repoRoot.Root)
 }
 
-// RemoteURL returns a URL for the remote copy of the repository.
+// RemoteURL returns a URL for the remote copy of the repository,
+// after applying any configured rewrite rules.
 func (d Dependency) RemoteURL() string {
-	return d.repoRoot.Repo
+	if d.repoRoot.Repo == "" {
+		return ""
+	}
+	return config.rewriteURL(d.repoRoot.Repo)
+}
+
+// remote is a named remote copy of a repository.
+type remote struct {
+	name string
+	url  string
+}
+
+// remotes returns the main remote of d's repository followed
+// by any configured mirrors, named mirror1, mirror2, and so on.
+func (d Dependency) remotes() []remote {
+	a := []remote{{"main", d.RemoteURL()}}
+	for i, url := range config.mirrors(d.repoRoot.Root) {
+		a = append(a, remote{fmt.Sprint("mirror", i+1), url})
+	}
+	return a
 }
 
 // FastRemotePath returns the url of a local disk clone of the
@@ -261,7 +351,39 @@ func (d Dependency) WorkdirRoot() string {
 // Gopath returns a path to a parent of Workdir such that using
 // Gopath in GOPATH makes d available to the go tool.
 func (d Dependency) Gopath() string {
-	return filepath.Join(spool, "rev", d.Rev[:2], d.Rev[2:])
+	return revGopath(spool, d.Rev)
+}
+
+// revGopath returns the GOPATH directory holding the checkout
+// of rev in spool directory dir. Revs are checked by checkRev
+// before anything is checked out; a bad one gets a directory
+// that is never populated, rather than a panic.
+func revGopath(dir, rev string) string {
+	if len(rev) < 3 {
+		return filepath.Join(dir, "rev", "bad", rev)
+	}
+	return filepath.Join(dir, "rev", rev[:2], rev[2:])
+}
+
+// checkRev returns an error if d.Rev can't be a revision ID.
+func (d Dependency) checkRev() error {
+	if len(d.Rev) < 3 || strings.ContainsAny(d.Rev, `/\`) || strings.HasPrefix(d.Rev, ".") {
+		return fmt.Errorf("%s: bad revision %q", d.ImportPath, d.Rev)
+	}
+	return nil
+}
+
+// readOnlyGopath returns the Gopath of an existing checkout
+// of d's commit in one of the read-only spools, or "" if
+// there is none.
+func (d Dependency) readOnlyGopath() string {
+	for _, dir := range config.ReadOnlySpools {
+		gopath := revGopath(dir, d.Rev)
+		if d.checkedOut(gopath) {
+			return gopath
+		}
+	}
+	return ""
 }
 
 // CreateRepo creates an empty repo in d.RepoPath().
@@ -278,7 +400,12 @@ func (d Dependency) CreateRepo(fastRemote, mainRemote string) error {
 	return d.link(mainRemote, d.RemoteURL())
 }
 
+// link adds a remote to d's repo. Empty URLs, which can
+// occur in offline mode, are skipped.
 func (d Dependency) link(remote, url string) error {
+	if url == "" {
+		return nil
+	}
 	return d.vcs.link(d.RepoPath(), remote, url)
 }
 
@@ -293,21 +420,71 @@ func (d Dependency) fetchAndCheckout(remote string) error {
 }
 
 func (d Dependency) fetch(remote string) error {
-	return d.vcs.fetch(d.RepoPath(), remote)
+	return d.vcs.fetch(d.RepoPath(), remote, d.Rev)
 }
 
+// checkout writes the files of d.Rev into d.Gopath().
+// A new checkout, full or sparse, is made in a temporary
+// directory and renamed into place, so an interrupted checkout
+// leaves nothing behind that looks complete.
 func (d Dependency) checkout() error {
-	dir := d.WorkdirRoot()
-	if exists(dir) {
+	if d.checkedOut(d.Gopath()) {
 		return nil
 	}
 	if !d.vcs.exists(d.RepoPath(), d.Rev) {
 		return fmt.Errorf("unknown rev %s for %s", d.Rev, d.ImportPath)
 	}
+	if exists(d.Gopath()) {
+		// Adding to or completing a sparse checkout, whose
+		// marker lists only the directories already done.
+		return d.checkoutIn(d.Gopath())
+	}
+	parent := filepath.Dir(d.Gopath())
+	if err := os.MkdirAll(parent, 0777); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(parent, filepath.Base(d.Gopath())+".tmp")
+	if err != nil {
+		return err
+	}
+	addCleanup(tmp)
+	defer removeCleanup(tmp)
+	if err := d.checkoutIn(tmp); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, d.Gopath()); err != nil {
+		os.RemoveAll(tmp)
+		if d.checkedOut(d.Gopath()) {
+			return nil // another deppy got there first
+		}
+		return err
+	}
+	return nil
+}
+
+// checkoutIn checks out the files of d needed in the sandbox
+// into GOPATH directory gopath: the directories listed in
+// d.sparseDirs, if the VCS allows, or else the whole repo.
+func (d Dependency) checkoutIn(gopath string) error {
+	dir := filepath.Join(gopath, "src", filepath.FromSlash(d.repoRoot.Root))
 	if err := os.MkdirAll(dir, 0777); err != nil {
 		return err
 	}
-	return d.vcs.checkout(dir, d.Rev, d.RepoPath())
+	if v, ok := d.vcs.(sparseVCS); ok && d.sparseDirs != nil {
+		err := d.checkoutSparse(v, gopath)
+		if err != errNoSparse {
+			return err
+		}
+	}
+	if err := d.vcs.checkout(dir, d.Rev, d.RepoPath()); err != nil {
+		return err
+	}
+	err := os.Remove(d.sparseMarker(gopath))
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return err
 }
 
 // containsPathPrefix returns whether any string in a
@@ -343,7 +520,7 @@ func goVersion() (string, error) {
 	// version, so we can't just use runtime.Version here.
 	cmd := exec.Command("go", "version")
 	cmd.Stderr = os.Stderr
-	out, err := cmd.Output()
+	out, err := outputCmd(cmd)
 	if err != nil {
 		return "", err
 	}
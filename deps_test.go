@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestFindDeps(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	defer func() { depsFile = "Deps" }()
+	tmp, err := ioutil.TempDir("", "deppyfind")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	tmp, _ = filepath.EvalSymlinks(tmp)
+	writeFile(filepath.Join(tmp, "Deps"), "{}")
+	writeFile(filepath.Join(tmp, "ci", "Deps"), "{}")
+	writeFile(filepath.Join(tmp, "a", "Deps.release"), "{}")
+	writeFile(filepath.Join(tmp, "a", "b", "x.go"), "package b")
+	abs := filepath.Join(tmp, "ci", "Deps")
+
+	var cases = []struct {
+		deps string
+		dir  string // relative to tmp
+		want string // relative to tmp, "" for none
+	}{
+		{"Deps", "a/b", "Deps"},
+		{"Deps.release", "a/b", "a/Deps.release"},
+		{"Deps.release", ".", ""},
+		{"ci/Deps", "a/b", "ci/Deps"},
+		{abs, "a/b", "ci/Deps"},
+		{filepath.Join(tmp, "missing"), "a", ""},
+	}
+	for _, test := range cases {
+		depsFile = test.deps
+		if err := os.Chdir(filepath.Join(tmp, test.dir)); err != nil {
+			t.Fatal(err)
+		}
+		var got string
+		if dir := findDeps(); dir != "" {
+			got, _ = filepath.Rel(tmp, depsPath(dir))
+		}
+		if want := filepath.FromSlash(test.want); got != want {
+			t.Errorf("-deps %s in %s: got %q, want %q", test.deps, test.dir, got, want)
+		}
+	}
+}
+
+func TestPkgDeps(t *testing.T) {
+	defer func() { depsFile = "Deps" }()
+	tmp, err := ioutil.TempDir("", "deppypkg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	writeFile(filepath.Join(tmp, "project", "Deps.release"), "{}")
+	writeFile(filepath.Join(tmp, "other", "Deps"), "{}")
+
+	depsFile = "Deps.release"
+	var cases = []struct {
+		dir  string
+		want string
+	}{
+		{"project/cmd", "project/Deps.release"},
+		{"other/pkg", "other/Deps"},
+		{"none", ""},
+	}
+	for _, test := range cases {
+		got := pkgDeps(filepath.Join(tmp, filepath.FromSlash(test.dir)))
+		if got != "" {
+			got, _ = filepath.Rel(tmp, got)
+		}
+		if want := filepath.FromSlash(test.want); got != want {
+			t.Errorf("pkgDeps(%s) = %q, want %q", test.dir, got, want)
+		}
+	}
+}
+
+func TestCheckRev(t *testing.T) {
+	var cases = []struct {
+		rev string
+		ok  bool
+	}{
+		{"5dd23f8e17b26873825624740bac83459e728256", true},
+		{"1", false},
+		{"", false},
+		{"../../x", false},
+		{"v1/x", false},
+	}
+	for _, test := range cases {
+		d := Dependency{ImportPath: "example.com/x", Rev: test.rev}
+		if err := d.checkRev(); (err == nil) != test.ok {
+			t.Errorf("checkRev(%q) = %v want ok %v", test.rev, err, test.ok)
+		}
+		d.Gopath() // must not panic
+	}
+}
+
+func TestCheckoutAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deppychild")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(s string) { spool = s }(spool)
+	spool = filepath.Join(dir, "spool")
+
+	fail := true
+	v := &cmdVCS{
+		ExistsFunc: func(v *cmdVCS, dir, rev string) bool { return true },
+		CheckoutFunc: func(v *cmdVCS, dir, rev, repo string) error {
+			if err := writeFile(filepath.Join(dir, "a.go"), pkg("a")); err != nil {
+				return err
+			}
+			if fail {
+				return errors.New("interrupted")
+			}
+			return nil
+		},
+	}
+	d := Dependency{
+		ImportPath: "example.com/a",
+		Rev:        "0123456789",
+		repoRoot:   &vcs.RepoRoot{Root: "example.com/a"},
+		vcs:        v,
+	}
+	if err := d.checkout(); err == nil {
+		t.Fatal("checkout succeeded, want error")
+	}
+	if d.checkedOut(d.Gopath()) {
+		t.Error("failed checkout looks complete")
+	}
+	if fis, _ := ioutil.ReadDir(filepath.Dir(d.Gopath())); len(fis) != 0 {
+		t.Errorf("failed checkout left %d files", len(fis))
+	}
+	fail = false
+	if err := d.checkout(); err != nil {
+		t.Fatal(err)
+	}
+	if !exists(filepath.Join(d.WorkdirRoot(), "a.go")) {
+		t.Error("a.go not checked out")
+	}
+}
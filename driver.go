@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// A VCS not built into deppy can be supported by an external
+// driver: a program named deppy-vcs-NAME on PATH, where NAME
+// is the VCS name used in Resolve entries, e.g. "fossil".
+//
+// Deppy runs the driver once per operation. It writes a
+// driverRequest as JSON to the driver's standard input and
+// reads a driverResponse as JSON from its standard output.
+// The driver's standard error is passed through. The
+// operations, and the request fields each one uses, are:
+//
+//	root      Dir, SrcRoot     Result: import path of the repo root
+//	                           containing Dir, relative to SrcRoot
+//	identify  Dir              Result: revision of the working copy
+//	describe  Dir, Rev         Result: tag or description of Rev
+//	dirty     Dir, Rev         Bool: the working copy differs from Rev
+//	remote    Dir              Result: URL the working copy came from
+//	create    Dir              make an empty sandbox repo in Dir
+//	link      Dir, Remote, URL set (or replace) a named remote
+//	exists    Dir, Rev         Bool: Rev is present in the repo
+//	fetch     Dir, Remote, Rev fetch from the named remote
+//	checkout  Dir, Rev, Repo   write the files of Rev in sandbox
+//	                           repo Repo into the empty Dir
+//	clone     Dir, URL         make a working copy of URL in Dir
+//	download  Dir              fetch updates into a working copy
+//	revsync   Dir, Rev         update a working copy to Rev
+//
+// A driver reports failure, including an unknown operation,
+// by setting Error in its response.
+
+const driverPrefix = "deppy-vcs-"
+
+type driverRequest struct {
+	Op      string
+	Dir     string
+	SrcRoot string `json:",omitempty"`
+	Rev     string `json:",omitempty"`
+	Remote  string `json:",omitempty"`
+	URL     string `json:",omitempty"`
+	Repo    string `json:",omitempty"`
+}
+
+type driverResponse struct {
+	Result string
+	Bool   bool
+	Error  string
+}
+
+// external is a VCS driven by an external driver program.
+type external struct {
+	cmd  string // VCS name, e.g. "fossil"
+	path string // path to the driver program
+}
+
+var (
+	externalsOnce sync.Once
+	externals     []*external
+)
+
+// findExternals returns the driver programs found on PATH.
+// Earlier PATH entries take precedence, as usual.
+func findExternals() []*external {
+	externalsOnce.Do(func() {
+		seen := make(map[string]bool)
+		for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+			matches, _ := filepath.Glob(filepath.Join(dir, driverPrefix+"*"))
+			for _, path := range matches {
+				name := strings.TrimPrefix(filepath.Base(path), driverPrefix)
+				name = strings.TrimSuffix(name, ".exe")
+				if seen[name] {
+					continue
+				}
+				seen[name] = true
+				externals = append(externals, &external{name, path})
+			}
+		}
+	})
+	return externals
+}
+
+// lookupExternal returns the external driver for the VCS
+// called name, or nil if there is none.
+func lookupExternal(name string) VCS {
+	for _, e := range findExternals() {
+		if e.cmd == name {
+			return e
+		}
+	}
+	return nil
+}
+
+// externalFromDir asks each external driver whether dir is
+// in one of its repos, and returns the first driver that
+// claims it along with the import path of the repo root.
+func externalFromDir(dir, srcRoot string) (VCS, string) {
+	for _, e := range findExternals() {
+		res, err := e.call(driverRequest{Op: "root", Dir: dir, SrcRoot: srcRoot})
+		if err == nil && res.Result != "" {
+			return e, res.Result
+		}
+	}
+	return nil, ""
+}
+
+// call runs the driver program for a single request.
+func (e *external) call(req driverRequest) (*driverResponse, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	c := exec.Command(e.path)
+	c.Stdin = bytes.NewReader(b)
+	c.Stderr = os.Stderr
+	out, err := outputCmd(c)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %s", filepath.Base(e.path), req.Op, err)
+	}
+	res := new(driverResponse)
+	if err := json.Unmarshal(out, res); err != nil {
+		return nil, fmt.Errorf("%s %s: bad response: %s", filepath.Base(e.path), req.Op, err)
+	}
+	if res.Error != "" {
+		return nil, fmt.Errorf("%s %s: %s", e.cmd, req.Op, res.Error)
+	}
+	return res, nil
+}
+
+func (e *external) do(req driverRequest) error {
+	_, err := e.call(req)
+	return err
+}
+
+func (e *external) name() string {
+	return e.cmd
+}
+
+func (e *external) identify(dir string) (string, error) {
+	res, err := e.call(driverRequest{Op: "identify", Dir: dir})
+	if err != nil {
+		return "", err
+	}
+	return res.Result, nil
+}
+
+func (e *external) describe(dir, rev string) string {
+	res, err := e.call(driverRequest{Op: "describe", Dir: dir, Rev: rev})
+	if err != nil {
+		return ""
+	}
+	return res.Result
+}
+
+func (e *external) isDirty(dir, rev string) bool {
+	res, err := e.call(driverRequest{Op: "dirty", Dir: dir, Rev: rev})
+	return err != nil || res.Bool
+}
+
+func (e *external) remoteURL(dir string) string {
+	res, err := e.call(driverRequest{Op: "remote", Dir: dir})
+	if err != nil {
+		return ""
+	}
+	return res.Result
+}
+
+func (e *external) canSandbox() bool {
+	return true
+}
+
+func (e *external) create(dir string) error {
+	return e.do(driverRequest{Op: "create", Dir: dir})
+}
+
+func (e *external) link(dir, remote, url string) error {
+	return e.do(driverRequest{Op: "link", Dir: dir, Remote: remote, URL: url})
+}
+
+func (e *external) exists(dir, rev string) bool {
+	res, err := e.call(driverRequest{Op: "exists", Dir: dir, Rev: rev})
+	return err == nil && res.Bool
+}
+
+func (e *external) fetch(dir, remote, rev string) error {
+	return e.do(driverRequest{Op: "fetch", Dir: dir, Remote: remote, Rev: rev})
+}
+
+func (e *external) checkout(dir, rev, repo string) error {
+	return e.do(driverRequest{Op: "checkout", Dir: dir, Rev: rev, Repo: repo})
+}
+
+func (e *external) clone(dir, url string) error {
+	return e.do(driverRequest{Op: "clone", Dir: dir, URL: url})
+}
+
+func (e *external) download(dir string) error {
+	return e.do(driverRequest{Op: "download", Dir: dir})
+}
+
+func (e *external) RevSync(dir, rev string) error {
+	return e.do(driverRequest{Op: "revsync", Dir: dir, Rev: rev})
+}
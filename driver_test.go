@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// fakeDriver answers every request with its own input
+// as the Result, and fails for op "fail".
+const fakeDriver = `#!/bin/sh
+req=$(cat)
+case "$req" in
+*'"Op":"fail"'*) echo '{"Error":"failed"}' ;;
+*'"Op":"exists"'*) echo '{"Bool":true}' ;;
+*) printf '{"Result":%s}\n' "$(printf '%s' "$req" | sed 's/\\/\\\\/g; s/"/\\"/g; s/^/"/; s/$/"/')" ;;
+esac
+`
+
+func TestExternalDriver(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	dir, err := ioutil.TempDir("", "deppydriver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, driverPrefix+"fake")
+	err = ioutil.WriteFile(path, []byte(fakeDriver), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &external{"fake", path}
+
+	g, err := e.identify("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"Op":"identify","Dir":"/a"}`; g != want {
+		t.Errorf("identify = %s want %s", g, want)
+	}
+	if g, want := e.describe("/a", "r1"), `{"Op":"describe","Dir":"/a","Rev":"r1"}`; g != want {
+		t.Errorf("describe = %s want %s", g, want)
+	}
+	if !e.exists("/a", "r1") {
+		t.Errorf("exists = false want true")
+	}
+	if err := e.do(driverRequest{Op: "fail"}); err == nil {
+		t.Errorf("fail err = nil want error")
+	}
+}
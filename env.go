@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var cmdEnv = &Command{
+	Usage: "env [-shell name] [-merge]",
+	Short: "print environment settings for the sandbox",
+	Long: `
+Env prints commands that set up a shell to use the sandbox for
+the dependencies listed in file Deps, for example
+
+	eval "$(deppy env)"
+
+It sets these variables:
+
+	GOPATH              the sandbox, followed by the outer GOPATH
+	GOBIN               where go install puts commands, as before
+	DEPPY_OUTER_GOPATH  the GOPATH from before
+	DEPPY_OUTER_GOBIN   the GOBIN from before, if any
+	DEPPY_DEPS_HASH     SHA-1 of file Deps
+
+Setting GOBIN keeps go install from putting commands in the
+sandbox. If DEPPY_OUTER_GOPATH is already set, it is used as
+the outer GOPATH, so evaluating the output again is harmless.
+
+The -shell flag selects the syntax: bash, zsh, fish, or json.
+The default is taken from $SHELL, or else bash. The -merge flag
+is as for 'deppy go'.
+`,
+	Run: runEnv,
+}
+
+var cmdHook = &Command{
+	Usage: "hook [-shell name] [-merge]",
+	Short: "print environment settings when Deps changes",
+	Long: `
+Hook is like env, but meant to run often, from direnv or before
+each shell prompt, as in
+
+	PROMPT_COMMAND='eval "$(deppy hook)"'
+
+It prints nothing if DEPPY_DEPS_HASH matches file Deps. Otherwise
+it prints the settings env would, reusing the settings computed
+earlier for the same Deps file when the sandbox is still there.
+Outside a project, it restores the variables in effect before
+deppy env or hook, if any.
+`,
+	Run: runHook,
+}
+
+var envShell string
+
+func init() {
+	for _, cmd := range []*Command{cmdEnv, cmdHook} {
+		cmd.Flag.StringVar(&envShell, "shell", defaultShell(), "output syntax: bash, zsh, fish, or json")
+		cmd.Flag.BoolVar(&sandboxMerge, "merge", false, "use a single merged GOPATH entry")
+	}
+}
+
+var shells = []string{"bash", "zsh", "fish", "json"}
+
+func defaultShell() string {
+	sh := filepath.Base(os.Getenv("SHELL"))
+	for _, s := range shells {
+		if s == sh {
+			return sh
+		}
+	}
+	return "bash"
+}
+
+func runEnv(cmd *Command, args []string) {
+	if jsonOutput {
+		envShell = "json"
+	}
+	if len(args) != 0 || !validShell(envShell) {
+		cmd.UsageExit()
+	}
+	vars, err := sandboxEnv(findDepsJSON())
+	if err != nil {
+		log.Fatalln(err)
+	}
+	printEnv(os.Stdout, envShell, vars, nil)
+}
+
+func runHook(cmd *Command, args []string) {
+	if jsonOutput {
+		envShell = "json"
+	}
+	if len(args) != 0 || !validShell(envShell) {
+		cmd.UsageExit()
+	}
+	dir := findDeps()
+	if dir == "" {
+		if _, ok := os.LookupEnv("DEPPY_OUTER_GOPATH"); ok {
+			vars, unset := outerEnv()
+			printEnv(os.Stdout, envShell, vars, unset)
+		}
+		return
+	}
+	path := depsPath(dir)
+	hash, err := fileHash(path)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if hash == os.Getenv("DEPPY_DEPS_HASH") {
+		return
+	}
+	cache := filepath.Join(spool, "env", envCacheKey(hash))
+	vars, err := readEnvCache(cache)
+	if err != nil {
+		vars, err = sandboxEnv(path)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if b, err := json.Marshal(vars); err == nil {
+			writeFile(cache, string(b))
+		}
+	}
+	printEnv(os.Stdout, envShell, vars, nil)
+}
+
+func validShell(sh string) bool {
+	for _, s := range shells {
+		if s == sh {
+			return true
+		}
+	}
+	return false
+}
+
+// sandboxEnv returns the variables that put the sandbox for
+// the Deps file at path into effect.
+func sandboxEnv(path string) (map[string]string, error) {
+	hash, err := fileHash(path)
+	if err != nil {
+		return nil, err
+	}
+	gopath, err := loadGopath(path)
+	if err != nil {
+		return nil, err
+	}
+	outer, outerBin := outerGopath(), outerGobin()
+	vars := map[string]string{
+		"GOPATH":             gopath,
+		"DEPPY_OUTER_GOPATH": outer,
+		"DEPPY_OUTER_GOBIN":  outerBin,
+		"DEPPY_DEPS_HASH":    hash,
+	}
+	if outer != "" {
+		vars["GOPATH"] += string(filepath.ListSeparator) + outer
+	}
+	vars["GOBIN"] = outerBin
+	if outerBin == "" {
+		// Not set before; use the outer default.
+		if list := filepath.SplitList(outer); len(list) > 0 {
+			vars["GOBIN"] = filepath.Join(list[0], "bin")
+		} else if bin, err := gobin(); err == nil {
+			vars["GOBIN"] = bin
+		}
+	}
+	return vars, nil
+}
+
+// outerEnv returns the variables that undo sandboxEnv.
+func outerEnv() (vars map[string]string, unset []string) {
+	vars = map[string]string{"GOPATH": outerGopath()}
+	unset = []string{"DEPPY_OUTER_GOPATH", "DEPPY_OUTER_GOBIN", "DEPPY_DEPS_HASH"}
+	if gobin := outerGobin(); gobin != "" {
+		vars["GOBIN"] = gobin
+	} else {
+		unset = append(unset, "GOBIN")
+	}
+	return vars, unset
+}
+
+// outerGopath returns the GOPATH in effect before the
+// environment printed by deppy env, if any.
+func outerGopath() string {
+	if s, ok := os.LookupEnv("DEPPY_OUTER_GOPATH"); ok {
+		return s
+	}
+	return os.Getenv("GOPATH")
+}
+
+// outerGobin is like outerGopath, for GOBIN.
+func outerGobin() string {
+	if _, ok := os.LookupEnv("DEPPY_OUTER_GOPATH"); ok {
+		return os.Getenv("DEPPY_OUTER_GOBIN")
+	}
+	return os.Getenv("GOBIN")
+}
+
+// envCacheKey names the cached hook settings for the Deps
+// file with the given hash and the current flags and outer
+// environment.
+func envCacheKey(hash string) string {
+	key := strings.Join([]string{hash, fmt.Sprint(sandboxMerge), outerGopath(), outerGobin()}, "\x00")
+	return fmt.Sprintf("%x", sha1.Sum([]byte(key)))
+}
+
+// readEnvCache reads settings saved by runHook. It fails if
+// a sandbox directory they refer to is gone.
+func readEnvCache(name string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var vars map[string]string
+	if err := json.Unmarshal(b, &vars); err != nil {
+		return nil, err
+	}
+	outer := vars["DEPPY_OUTER_GOPATH"]
+	sandbox := strings.TrimSuffix(vars["GOPATH"], string(filepath.ListSeparator)+outer)
+	for _, dir := range filepath.SplitList(sandbox) {
+		if !exists(dir) {
+			return nil, os.ErrNotExist
+		}
+	}
+	return vars, nil
+}
+
+func fileHash(name string) (string, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha1.Sum(b)), nil
+}
+
+// printEnv writes commands for shell sh that set vars and
+// unset the variables named in unset.
+func printEnv(w io.Writer, sh string, vars map[string]string, unset []string) {
+	var names []string
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	switch sh {
+	case "json":
+		m := make(map[string]interface{})
+		for name, v := range vars {
+			m[name] = v
+		}
+		for _, name := range unset {
+			m[name] = nil
+		}
+		b, _ := json.MarshalIndent(m, "", "\t")
+		fmt.Fprintf(w, "%s\n", b)
+	case "fish":
+		for _, name := range names {
+			fmt.Fprintf(w, "set -gx %s %s;\n", name, fishQuote(vars[name]))
+		}
+		for _, name := range unset {
+			fmt.Fprintf(w, "set -e %s;\n", name)
+		}
+	default:
+		for _, name := range names {
+			fmt.Fprintf(w, "export %s=%s\n", name, shQuote(vars[name]))
+		}
+		for _, name := range unset {
+			fmt.Fprintf(w, "unset %s\n", name)
+		}
+	}
+}
+
+// shQuote quotes s for bash and zsh.
+func shQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// fishQuote quotes s for fish, where \ and ' are
+// escaped inside single quotes.
+func fishQuote(s string) string {
+	var buf bytes.Buffer
+	buf.WriteByte('\'')
+	for _, c := range s {
+		if c == '\\' || c == '\'' {
+			buf.WriteByte('\\')
+		}
+		buf.WriteRune(c)
+	}
+	buf.WriteByte('\'')
+	return buf.String()
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrintEnv(t *testing.T) {
+	vars := map[string]string{"GOPATH": "/a b:/c", "X": `it's \n`}
+	unset := []string{"Y"}
+	var cases = []struct {
+		shell string
+		want  string
+	}{
+		{"bash", "export GOPATH='/a b:/c'\nexport X='it'\\''s \\n'\nunset Y\n"},
+		{"zsh", "export GOPATH='/a b:/c'\nexport X='it'\\''s \\n'\nunset Y\n"},
+		{"fish", "set -gx GOPATH '/a b:/c';\nset -gx X 'it\\'s \\\\n';\nset -e Y;\n"},
+		{"json", "{\n\t\"GOPATH\": \"/a b:/c\",\n\t\"X\": \"it's \\\\n\",\n\t\"Y\": null\n}\n"},
+	}
+	for _, test := range cases {
+		var buf bytes.Buffer
+		printEnv(&buf, test.shell, vars, unset)
+		if got := buf.String(); got != test.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.shell, got, test.want)
+		}
+	}
+}
+
+func TestOuterGopath(t *testing.T) {
+	defer os.Setenv("GOPATH", os.Getenv("GOPATH"))
+	defer os.Setenv("GOBIN", os.Getenv("GOBIN"))
+	os.Setenv("GOPATH", "/sandbox:/outer")
+	os.Setenv("GOBIN", "/outer/bin")
+
+	if _, ok := os.LookupEnv("DEPPY_OUTER_GOPATH"); ok {
+		t.Skip("DEPPY_OUTER_GOPATH already set")
+	}
+	if g := outerGopath(); g != "/sandbox:/outer" {
+		t.Errorf("outerGopath() = %q, want $GOPATH", g)
+	}
+
+	os.Setenv("DEPPY_OUTER_GOPATH", "/outer")
+	os.Setenv("DEPPY_OUTER_GOBIN", "")
+	defer os.Unsetenv("DEPPY_OUTER_GOPATH")
+	defer os.Unsetenv("DEPPY_OUTER_GOBIN")
+	if g := outerGopath(); g != "/outer" {
+		t.Errorf("outerGopath() = %q, want /outer", g)
+	}
+	if g := outerGobin(); g != "" {
+		t.Errorf("outerGobin() = %q, want empty", g)
+	}
+	vars, unset := outerEnv()
+	if vars["GOPATH"] != "/outer" || vars["GOBIN"] != "" {
+		t.Errorf("outerEnv() vars = %v", vars)
+	}
+	if len(unset) != 4 || unset[3] != "GOBIN" {
+		t.Errorf("outerEnv() unset = %v", unset)
+	}
+}
+
+// TestOuterGopathCommands checks that with the environment of
+// deppy env in effect, restore downloads into, and go list
+// reads from, the outer GOPATH rather than the sandbox.
+func TestOuterGopathCommands(t *testing.T) {
+	if _, ok := os.LookupEnv("DEPPY_OUTER_GOPATH"); ok {
+		t.Skip("DEPPY_OUTER_GOPATH already set")
+	}
+	dir, err := ioutil.TempDir("", "deppyenv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("GOPATH", os.Getenv("GOPATH"))
+	defer os.Unsetenv("DEPPY_OUTER_GOPATH")
+	defer func(s string) { restoreInto = s }(restoreInto)
+	restoreInto = ""
+
+	outer := filepath.Join(dir, "outer")
+	if err := writeFile(filepath.Join(outer, "src", "example.com", "o", "o.go"), pkg("o")); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("GOPATH", filepath.Join(dir, "sandbox")+string(filepath.ListSeparator)+outer)
+	os.Setenv("DEPPY_OUTER_GOPATH", outer)
+
+	g, err := downloadGopath()
+	if err != nil || len(g) != 1 || g[0] != outer {
+		t.Errorf("downloadGopath() = %v, %v want [%s]", g, err, outer)
+	}
+	os.Setenv("GOPATH", filepath.Join(dir, "sandbox"))
+	ps, err := LoadPackages("example.com/o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(outer, "src", "example.com", "o"); len(ps) != 1 || ps[0].Dir != want {
+		t.Errorf("LoadPackages found %+v, want dir %s", ps, want)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+var cmdExec = &Command{
+	Usage: "exec [-merge] command [arguments]",
+	Short: "run a command in the sandbox",
+	Long: `
+Exec runs the named command with GOPATH set to the sandbox
+for the dependencies listed in file Deps, followed by the
+GOPATH from the environment. It is like 'deppy go', but for
+other tools, such as linters, code generators, or make:
+
+	deppy exec golint ./...
+
+The -merge flag is as for 'deppy go'. Exec exits with the
+command's exit status, and passes signals on to it.
+`,
+	Run: runExec,
+}
+
+func init() {
+	cmdExec.Flag.BoolVar(&sandboxMerge, "merge", false, "use a single merged GOPATH entry")
+}
+
+func runExec(cmd *Command, args []string) {
+	if len(args) == 0 {
+		cmd.UsageExit()
+	}
+	stop := catchInterrupts()
+	gopath := prepareGopath()
+	stop()
+	runChild(sandboxCommand(gopath, args[0], args[1:]...))
+}
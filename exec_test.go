@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestSandboxCommand(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not installed")
+	}
+	defer os.Setenv("GOPATH", os.Getenv("GOPATH"))
+	var cases = []struct {
+		outer string
+		want  string
+	}{
+		{"", "/sandbox"},
+		{"/outer", "/sandbox:/outer"},
+	}
+	for _, test := range cases {
+		os.Setenv("GOPATH", test.outer)
+		c := sandboxCommand("/sandbox", "sh", "-c", `test "$GOPATH" = "$0"`, test.want)
+		status, err := runForwarding(c)
+		if err != nil || status != 0 {
+			t.Errorf("outer GOPATH %q: want GOPATH %q, got status %d, err %v", test.outer, test.want, status, err)
+		}
+	}
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 )
 
 var cmdGet = &Command{
-	Usage: "get [packages]",
+	Usage: "get [packages] [importpath@version]",
 	Short: "download and install packages with specified dependencies",
 	Long: `
 Get downloads to GOPATH the packages named by the import paths, and installs
@@ -16,17 +20,50 @@ them with the dependencies specified in their Deps files.
 If any of the packages do not have Deps files, those are installed
 as if by go get.
 
+An argument of the form importpath@version, where version is a
+revision ID, tag, or branch, instead fetches that version into the
+spool used by 'deppy go'. Get records it in the current project's
+Deps file, along with a description of the revision, updating any
+other entries from the same repo to match. It then installs the
+package as 'deppy go install' would. Without a Deps file, the
+package is installed but not recorded.
+
 For more about specifying packages, see 'go help packages'.
 `,
 	Run: runGet,
 }
 
+// A revResolver can find the revision named by a tag or
+// branch. If it can't for a particular repo, it returns
+// errNoResolve.
+type revResolver interface {
+	resolveRev(dir, remote, ver string) (string, error)
+}
+
+var errNoResolve = errors.New("cannot resolve revision names")
+
 func runGet(cmd *Command, args []string) {
 	if len(args) == 0 {
 		args = []string{"."}
 	}
+	var pinned []string
+	for i := 0; i < len(args); i++ {
+		if strings.Contains(args[i], "@") {
+			pinned = append(pinned, args[i])
+			args = append(args[:i], args[i+1:]...)
+			i--
+		}
+	}
+	if len(pinned) > 0 {
+		if err := getPinned(pinned); err != nil {
+			fatal(err)
+		}
+		if len(args) == 0 {
+			return
+		}
+	}
 
-	err := command("go", "get", "-d", args).Run()
+	err := runCmd(command("go", "get", "-d", args))
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -41,23 +78,194 @@ func runGet(cmd *Command, args []string) {
 		if pkg.Error.Err != "" {
 			log.Fatalln(pkg.Error.Err)
 		}
-		dir := findInParents(pkg.Dir, "Deps")
-		groups[dir] = append(groups[dir], pkg.ImportPath)
+		path := pkgDeps(pkg.Dir)
+		groups[path] = append(groups[path], pkg.ImportPath)
 	}
-	for dir, packages := range groups {
-		var c *exec.Cmd
-		if dir == "" {
-			c = command("go", "install", packages)
+	for path, packages := range groups {
+		if path == "" {
+			err = runCmd(command("go", "install", packages))
 		} else {
-			c = command("deppy", "go", "install", packages)
-			c.Dir = dir
+			var gopath string
+			gopath, err = loadGopath(path)
+			if err == nil {
+				err = goInstall(gopath, packages)
+			}
 		}
-		if err := c.Run(); err != nil {
+		if err != nil {
 			log.Fatalln(err)
 		}
 	}
 }
 
+// pkgDeps returns the path of the manifest for the package in
+// dir, or "" if it has none. It looks for depsFile first, then,
+// for packages of other projects, for file Deps.
+func pkgDeps(dir string) string {
+	if !filepath.IsAbs(depsFile) {
+		if d := findInParents(dir, depsFile); d != "" {
+			return filepath.Join(d, depsFile)
+		}
+	}
+	if d := findInParents(dir, "Deps"); d != "" {
+		return filepath.Join(d, "Deps")
+	}
+	return ""
+}
+
+// getPinned fetches each importpath@version in args into
+// the spool, records it in file Deps, if any, and installs it.
+func getPinned(args []string) error {
+	var g Deps
+	depsDir := findDeps()
+	if depsDir != "" {
+		if err := ReadDeps(depsPath(depsDir), &g); err != nil {
+			return err
+		}
+	}
+	var deps []Dependency
+	var paths []string
+	for _, arg := range args {
+		d, _, err := pinnedDep(arg, g.resolveTable())
+		if err != nil {
+			return err
+		}
+		deps = append(deps, d)
+		paths = append(paths, d.ImportPath)
+	}
+	if jsonOutput {
+		for _, d := range deps {
+			emit(Event{Action: "get", ImportPath: d.ImportPath, Rev: d.Rev, Comment: d.Comment})
+		}
+	}
+	if depsDir == "" {
+		warn("no Deps found; installing without recording versions")
+		gopaths, err := sandboxDeps(deps)
+		if err != nil {
+			return err
+		}
+		return goInstall(strings.Join(gopaths, string(filepath.ListSeparator)), paths)
+	}
+	for _, d := range deps {
+		g.pin(d)
+	}
+	f, err := os.Create(depsPath(depsDir))
+	if err != nil {
+		return err
+	}
+	if _, err := g.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return goInstall(prepareGopath(), paths)
+}
+
+// pinnedDep resolves the argument importpath@version using
+// table, fetches the version and checks it out in the sandbox.
+// It returns the dependency, with its Comment set, and the
+// GOPATH holding the checkout. A failed fetch is reported as
+// a failure.
+func pinnedDep(arg string, table []Resolution) (d Dependency, gopath string, err error) {
+	importPath, ver, err := splitPinned(arg)
+	if err != nil {
+		return d, "", err
+	}
+	d.ImportPath = importPath
+	if ps, err := LoadPackages(d.ImportPath); err == nil && len(ps) == 1 {
+		d.outerRoot = ps[0].Root
+	}
+	if err := d.resolve(table); err != nil {
+		return d, "", fmt.Errorf("%s: %s", arg, err)
+	}
+	err = d.fetchVersion(ver)
+	if err == nil {
+		gopath, err = sandbox(d)
+	}
+	if err != nil {
+		if jsonOutput {
+			emit(Event{Action: "fail", ImportPath: d.ImportPath, Rev: ver, Error: err.Error()})
+		}
+		return d, "", failure{fmt.Errorf("%s: %s", arg, err)}
+	}
+	d.Comment = d.vcs.describe(d.RepoPath(), d.Rev)
+	return d, gopath, nil
+}
+
+// splitPinned splits an argument of the form importpath@version.
+// Import paths can't contain "@", but versions can, as in
+// Subversion's "UUID@num".
+func splitPinned(arg string) (importPath, ver string, err error) {
+	i := strings.Index(arg, "@")
+	if i <= 0 || i == len(arg)-1 {
+		return "", "", fmt.Errorf("%s: want importpath@version", arg)
+	}
+	return arg[:i], arg[i+1:], nil
+}
+
+// fetchVersion sets d.Rev to the ID of the revision named by
+// ver, trying the main remote and then each mirror, and makes
+// sure it is in d's sandbox repo. If the VCS can't resolve
+// names, ver must be a revision ID.
+func (d *Dependency) fetchVersion(ver string) error {
+	if !exists(d.RepoPath()) {
+		if err := d.CreateRepo("fast", "main"); err != nil {
+			return err
+		}
+	}
+	rv, canResolve := d.vcs.(revResolver)
+	err := errors.New("no remote")
+	for _, r := range d.remotes() {
+		if r.url == "" && !offline {
+			continue
+		}
+		if err = d.link(r.name, r.url); err != nil {
+			continue
+		}
+		rev := ver
+		if canResolve {
+			rev, err = rv.resolveRev(d.RepoPath(), r.name, ver)
+			if err == errNoResolve {
+				rev, err, canResolve = ver, nil, false
+			}
+			if err != nil {
+				continue
+			}
+		}
+		d.Rev = rev
+		if err = d.checkRev(); err != nil {
+			break
+		}
+		if !offline {
+			if err = d.fetch(r.name); err != nil { // records rev, as sandbox does
+				continue
+			}
+		}
+		if !d.vcs.exists(d.RepoPath(), d.Rev) {
+			err = errors.New("unknown revision " + ver)
+			continue
+		}
+		return nil
+	}
+	if err != nil && !canResolve {
+		err = fmt.Errorf("%v (%s needs a revision ID, not a tag or branch)", err, d.vcs.name())
+	}
+	return err
+}
+
+// goInstall runs go install for packages, with GOPATH set to
+// gopath followed by the outer GOPATH.
+func goInstall(gopath string, packages []string) error {
+	if s := outerGopath(); s != "" {
+		gopath += string(filepath.ListSeparator) + s
+	}
+	c := command("go", "install", packages)
+	c.Env = append(envNoGopath(), "GOPATH="+gopath)
+	c.Stdout = os.Stdout
+	return runCmd(c)
+}
+
 // command is like exec.Command, but the returned
 // Cmd inherits stderr from the current process, and
 // elements of args may be either string or []string.
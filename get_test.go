@@ -0,0 +1,166 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestPin(t *testing.T) {
+	var cases = []struct {
+		have []Dependency
+		pin  Dependency
+		want []Dependency
+	}{
+		{
+			[]Dependency{{ImportPath: "a", Rev: "1"}, {ImportPath: "c", Rev: "1"}},
+			Dependency{ImportPath: "b", Rev: "2", Comment: "v2"},
+			[]Dependency{{ImportPath: "a", Rev: "1"}, {ImportPath: "b", Rev: "2", Comment: "v2"}, {ImportPath: "c", Rev: "1"}},
+		},
+		{
+			[]Dependency{{ImportPath: "a/x", Rev: "1"}, {ImportPath: "a/y", Rev: "1"}, {ImportPath: "ab", Rev: "1"}},
+			Dependency{ImportPath: "a/y", Rev: "2"},
+			[]Dependency{{ImportPath: "a/x", Rev: "2"}, {ImportPath: "a/y", Rev: "2"}, {ImportPath: "ab", Rev: "1"}},
+		},
+		{
+			nil,
+			Dependency{ImportPath: "a/z", Rev: "2"},
+			[]Dependency{{ImportPath: "a/z", Rev: "2"}},
+		},
+	}
+	for _, test := range cases {
+		g := &Deps{Deps: test.have}
+		test.pin.repoRoot = &vcs.RepoRoot{Root: "a"}
+		if test.pin.ImportPath == "b" {
+			test.pin.repoRoot.Root = "b"
+		}
+		g.pin(test.pin)
+		if len(g.Deps) != len(test.want) {
+			t.Errorf("pin(%s) = %+v want %+v", test.pin.ImportPath, g.Deps, test.want)
+			continue
+		}
+		for i, d := range g.Deps {
+			w := test.want[i]
+			if d.ImportPath != w.ImportPath || d.Rev != w.Rev || d.Comment != w.Comment {
+				t.Errorf("pin(%s) = %+v want %+v", test.pin.ImportPath, g.Deps, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestFetchVersion(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir, err := ioutil.TempDir("", "deppyget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(s string) { spool = s }(spool)
+	spool = filepath.Join(dir, "spool")
+
+	remote := filepath.Join(dir, "remote")
+	if err := writeFile(filepath.Join(remote, "r.go"), pkg("r")); err != nil {
+		t.Fatal(err)
+	}
+	run(t, remote, "git", "init", "-q")
+	run(t, remote, "git", "add", ".")
+	run(t, remote, "git", "commit", "-q", "-m", "one")
+	run(t, remote, "git", "tag", "-a", "-m", "v1", "v1")
+	tagged, _ := vcsGit.identify(remote)
+	run(t, remote, "git", "checkout", "-q", "-b", "dev")
+	run(t, remote, "git", "commit", "-q", "--allow-empty", "-m", "two")
+	dev, _ := vcsGit.identify(remote)
+	run(t, remote, "git", "checkout", "-q", "-")
+
+	var cases = []struct {
+		ver     string
+		rev     string
+		comment string
+	}{
+		{"v1", tagged, "v1"},
+		{"dev", dev, "v1-1-g" + dev[:7]},
+		{tagged, tagged, "v1"},
+	}
+	for _, test := range cases {
+		d := gitDep("example.com/r", remote, "")
+		if err := d.fetchVersion(test.ver); err != nil {
+			t.Errorf("fetchVersion(%s): %v", test.ver, err)
+			continue
+		}
+		if d.Rev != test.rev {
+			t.Errorf("fetchVersion(%s) rev = %s want %s", test.ver, d.Rev, test.rev)
+		}
+		if g := d.vcs.describe(d.RepoPath(), d.Rev); g != test.comment {
+			t.Errorf("describe %s = %q want %q", test.ver, g, test.comment)
+		}
+	}
+	// A revision ID is fetched on its own, not with every
+	// branch.
+	spool = filepath.Join(dir, "spool1")
+	d := gitDep("example.com/r", remote, "")
+	if err := d.fetchVersion(dev); err != nil {
+		t.Fatalf("fetchVersion(%s): %v", dev, err)
+	}
+	out, err := exec.Command("git", "--git-dir", d.RepoPath(), "for-each-ref", "refs/remotes", "refs/tags").Output()
+	if err != nil || len(out) > 0 {
+		t.Errorf("fetchVersion(%s) fetched refs:\n%s", dev, out)
+	}
+
+	for _, ver := range []string{"nosuch", "1"} {
+		d := gitDep("example.com/r", remote, "")
+		if err := d.fetchVersion(ver); err == nil {
+			t.Errorf("fetchVersion(%s) = %s, want error", ver, d.Rev)
+		}
+	}
+
+	// A VCS that can't resolve names takes only revision IDs.
+	noResolve := *vcsGit
+	noResolve.ResolveCmd = ""
+	spool = filepath.Join(dir, "spool2")
+	for _, test := range []struct {
+		ver string
+		ok  bool
+	}{
+		{"v1", false},
+		{"1", false},
+		{tagged, true},
+	} {
+		d := gitDep("example.com/r", remote, "")
+		d.vcs = &noResolve
+		err := d.fetchVersion(test.ver)
+		if (err == nil) != test.ok {
+			t.Errorf("fetchVersion(%s) without resolve: err = %v want ok %v", test.ver, err, test.ok)
+		}
+		if err == nil && d.Rev != tagged {
+			t.Errorf("fetchVersion(%s) without resolve: rev = %s want %s", test.ver, d.Rev, tagged)
+		}
+	}
+}
+
+func TestSplitPinned(t *testing.T) {
+	var cases = []struct {
+		arg        string
+		importPath string
+		ver        string
+		ok         bool
+	}{
+		{"example.com/x@v1", "example.com/x", "v1", true},
+		{"example.com/x@13f6e1a7-6b8e-4b8a-9c39-2c1e8d1a4f60@5", "example.com/x", "13f6e1a7-6b8e-4b8a-9c39-2c1e8d1a4f60@5", true},
+		{"example.com/x", "", "", false},
+		{"@v1", "", "", false},
+		{"example.com/x@", "", "", false},
+	}
+	for _, test := range cases {
+		importPath, ver, err := splitPinned(test.arg)
+		if (err == nil) != test.ok || importPath != test.importPath || ver != test.ver {
+			t.Errorf("splitPinned(%q) = %q, %q, %v want %q, %q, ok %v", test.arg, importPath, ver, err, test.importPath, test.ver, test.ok)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// gitFetch fetches just rev, with depth 1, if the remote
+// allows fetching a commit by ID. Otherwise it falls back
+// to FetchCmd, fetching every branch. It records the remote
+// that satisfied the fetch in ref refs/deppy/REMOTE/REV,
+// which also protects rev from garbage collection. If rev
+// is already present, it only records the ref; a shallow
+// fetch would cut off its history.
+func gitFetch(v *cmdVCS, dir, remote, rev string) error {
+	kv := []string{"remote", remote, "rev", rev}
+	if !v.exists(dir, rev) {
+		err := v.runVerboseOnly(dir, "fetch --quiet --depth 1 {remote} {rev}", kv...)
+		if err != nil {
+			err = v.run(dir, v.FetchCmd, kv...)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !v.exists(dir, rev) {
+		return nil // let checkout report it
+	}
+	return v.run(dir, "update-ref refs/deppy/{remote}/{rev} {rev}", kv...)
+}
+
+func gitCheckout(v *cmdVCS, dir, rev, repo string) error {
+	err := v.run(dir, v.CheckoutCmd, "rev", rev, "repo", repo)
+	if err != nil {
+		return err
+	}
+	return gitSubmodules(v, dir, rev, repo, nil)
+}
+
+// gitSubmodules populates the submodules of rev, if any, in
+// the checkout in dir, recursively. Each submodule has its
+// own repo in the spool, named after a hash of its URL.
+// If dirs is not nil, only submodules holding one of dirs,
+// or in one of their testdata trees, are populated.
+func gitSubmodules(v *cmdVCS, dir, rev, repo string, dirs []string) error {
+	if !exists(filepath.Join(dir, ".gitmodules")) {
+		return nil
+	}
+	mods, err := gitModules(v, dir)
+	if err != nil {
+		return err
+	}
+	for _, m := range mods {
+		if dirs != nil && !submoduleNeeded(m.path, dirs) {
+			continue
+		}
+		out, err := v.runOutput(dir, "--git-dir {repo} ls-tree {rev} -- {path}", "repo", repo, "rev", rev, "path", m.path)
+		if err != nil {
+			return err
+		}
+		f := strings.Fields(string(out))
+		if len(f) < 3 || f[1] != "commit" {
+			continue // not a submodule at this rev
+		}
+		commit := f[2]
+		base, _ := lookupGitRemote(v, repo, "main")
+		url := config.rewriteURL(submoduleURL(base, m.url))
+		subrepo := filepath.Join(spool, "repo", "_submodule", fmt.Sprintf("%x", sha1.Sum([]byte(url))))
+		if !exists(subrepo) {
+			if err := os.MkdirAll(subrepo, 0777); err != nil {
+				return err
+			}
+			if err := v.create(subrepo); err != nil {
+				return err
+			}
+		}
+		if err := v.link(subrepo, "main", url); err != nil {
+			return err
+		}
+		if !v.exists(subrepo, commit) {
+			if offline {
+				return errors.New("submodule " + m.path + ": " + commit + " not available offline")
+			}
+			if err := v.fetch(subrepo, "main", commit); err != nil {
+				return fmt.Errorf("submodule %s: %s", m.path, err)
+			}
+		}
+		subdir := filepath.Join(dir, filepath.FromSlash(m.path))
+		if err := os.MkdirAll(subdir, 0777); err != nil {
+			return err
+		}
+		if err := gitCheckout(v, subdir, commit, subrepo); err != nil {
+			return fmt.Errorf("submodule %s: %s", m.path, err)
+		}
+	}
+	return nil
+}
+
+// submoduleNeeded reports whether the submodule at path
+// holds one of dirs or is in one of their testdata trees.
+func submoduleNeeded(path string, dirs []string) bool {
+	for _, d := range dirs {
+		prefix := d + "/"
+		if d == "." {
+			prefix = ""
+		}
+		if containsPathPrefix([]string{path}, d) || strings.HasPrefix(path, prefix+"testdata/") {
+			return true
+		}
+	}
+	return false
+}
+
+type gitModule struct {
+	path string
+	url  string
+}
+
+// gitModules reads file .gitmodules in dir.
+func gitModules(v *cmdVCS, dir string) ([]gitModule, error) {
+	out, err := v.runOutput(dir, `config -f .gitmodules --get-regexp ^submodule\..*\.(path|url)$`)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	mods := make(map[string]*gitModule)
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		key, value := splitPair(sc.Text())
+		i := strings.LastIndex(key, ".")
+		name := strings.TrimPrefix(key[:i], "submodule.")
+		m := mods[name]
+		if m == nil {
+			m = new(gitModule)
+			mods[name] = m
+			names = append(names, name)
+		}
+		switch key[i+1:] {
+		case "path":
+			m.path = value
+		case "url":
+			m.url = value
+		}
+	}
+	var a []gitModule
+	for _, name := range names {
+		if m := mods[name]; m.path != "" && m.url != "" {
+			a = append(a, *m)
+		}
+	}
+	return a, sc.Err()
+}
+
+// lookupGitRemote returns the URL of the named remote
+// of the repo in dir.
+func lookupGitRemote(v *cmdVCS, dir, remote string) (string, error) {
+	out, err := v.runOutputVerboseOnly(dir, "config remote.{remote}.url", "remote", remote)
+	return string(bytes.TrimSpace(out)), err
+}
+
+// submoduleURL resolves a submodule URL, which may be
+// relative (starting with ./ or ../) to the URL of the
+// superproject's remote.
+func submoduleURL(base, url string) string {
+	if !strings.HasPrefix(url, "./") && !strings.HasPrefix(url, "../") {
+		return url
+	}
+	base = strings.TrimSuffix(base, "/")
+	for {
+		switch {
+		case strings.HasPrefix(url, "./"):
+			url = url[2:]
+		case strings.HasPrefix(url, "../"):
+			url = url[3:]
+			if i := strings.LastIndexAny(base, "/:"); i >= 0 && base[i] == ':' {
+				base = base[:i+1] // scp-like host:path
+			} else if i >= 0 {
+				base = base[:i]
+			}
+		case strings.HasSuffix(base, ":"):
+			return base + url
+		default:
+			return base + "/" + url
+		}
+	}
+}
+
+// gitRevSync checks out rev in the working copy in dir,
+// then updates its submodules, if any. In offline mode, the
+// submodules' commits must already be present.
+func gitRevSync(v *cmdVCS, dir, rev string) error {
+	if err := v.run(dir, v.vcs.TagSyncCmd, "tag", rev); err != nil {
+		return err
+	}
+	out, err := v.runOutput(dir, "rev-parse --show-toplevel")
+	if err != nil {
+		return err
+	}
+	top := string(bytes.TrimSpace(out))
+	if !exists(filepath.Join(top, ".gitmodules")) {
+		return nil
+	}
+	args := "submodule --quiet update --init --recursive"
+	if offline {
+		args += " --no-fetch"
+	}
+	return v.run(top, args)
+}
+
+// gitCheckoutDirs checks out the files directly in dirs, and
+// in their testdata trees, without touching other files, plus
+// file .gitmodules, the repo's manifest, for conflicts, and
+// the submodules that hold any of dirs.
+func gitCheckoutDirs(v *cmdVCS, dir, rev, repo string, dirs []string) error {
+	kv := []string{"repo", repo, "rev", rev}
+	out, err := v.runOutput(dir, "--git-dir {repo} ls-tree -r -z {rev}", kv...)
+	if err != nil {
+		return err
+	}
+	var files []string
+	for _, entry := range strings.Split(string(out), "\x00") {
+		// entry is "mode type object\tname"
+		i := strings.Index(entry, "\t")
+		if i < 0 || !strings.Contains(entry[:i], " blob ") {
+			continue
+		}
+		name := entry[i+1:]
+		if name == ".gitmodules" || isManifestName(name) {
+			files = append(files, name)
+			continue
+		}
+		for _, d := range dirs {
+			prefix := d + "/"
+			if d == "." {
+				prefix = ""
+			}
+			if path.Dir(name) == d || strings.HasPrefix(name, prefix+"testdata/") {
+				files = append(files, name)
+				break
+			}
+		}
+	}
+	const batch = 500 // keep command lines short
+	for len(files) > 0 {
+		n := len(files)
+		if n > batch {
+			n = batch
+		}
+		err := v.runArgs(dir, "--literal-pathspecs --git-dir {repo} --work-tree . checkout -q --force {rev} --", kv, files[:n]...)
+		if err != nil {
+			return err
+		}
+		files = files[n:]
+	}
+	return gitSubmodules(v, dir, rev, repo, dirs)
+}
+
+// gitIsAncestor is like isAncestor, but first fetches the
+// full history if the repo is shallow.
+func gitIsAncestor(v *cmdVCS, dir, a, b string) (bool, error) {
+	shallow := filepath.Join(dir, "shallow")
+	if exists(shallow) {
+		out, err := v.runOutput(dir, "remote")
+		if err != nil {
+			return false, err
+		}
+		for _, r := range strings.Fields(string(out)) {
+			if !exists(shallow) {
+				break
+			}
+			if offline && r != "fast" {
+				continue
+			}
+			v.runVerboseOnly(dir, "fetch --quiet --unshallow {remote}", "remote", r)
+		}
+		if exists(shallow) {
+			return false, errors.New("cannot fetch full history")
+		}
+	}
+	out, err := v.runOutput(dir, v.MergeBaseCmd, "a", a, "b", b)
+	if err != nil {
+		return false, err
+	}
+	return string(bytes.TrimSpace(out)) == a, nil
+}
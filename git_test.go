@@ -0,0 +1,264 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestSubmoduleURL(t *testing.T) {
+	var cases = []struct {
+		base string
+		url  string
+		want string
+	}{
+		{"https://example.com/a/b.git", "https://example.com/c", "https://example.com/c"},
+		{"https://example.com/a/b.git", "../c.git", "https://example.com/a/c.git"},
+		{"https://example.com/a/b/", "./c", "https://example.com/a/b/c"},
+		{"git@example.com:a/b", "../c", "git@example.com:a/c"},
+		{"git@example.com:a/b", "../../c", "git@example.com:c"},
+	}
+	for _, test := range cases {
+		g := submoduleURL(test.base, test.url)
+		if g != test.want {
+			t.Errorf("submoduleURL(%q, %q) = %q want %q", test.base, test.url, g, test.want)
+		}
+	}
+}
+
+func TestSandboxGitSubmodule(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir, err := ioutil.TempDir("", "deppygit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(s string) { spool = s }(spool)
+	spool = filepath.Join(dir, "spool")
+
+	lib := filepath.Join(dir, "lib")
+	if err := writeFile(filepath.Join(lib, "lib.h"), "int x;\n"); err != nil {
+		t.Fatal(err)
+	}
+	run(t, lib, "git", "init", "-q")
+	run(t, lib, "git", "add", ".")
+	run(t, lib, "git", "commit", "-q", "-m", "lib")
+
+	remote := filepath.Join(dir, "remote")
+	if err := writeFile(filepath.Join(remote, "a.go"), pkg("a")); err != nil {
+		t.Fatal(err)
+	}
+	run(t, remote, "git", "init", "-q")
+	run(t, remote, "git", "-c", "protocol.file.allow=always", "submodule", "--quiet", "add", lib, "c/lib")
+	run(t, remote, "git", "add", ".")
+	run(t, remote, "git", "commit", "-q", "-m", "a")
+	rev, err := vcsGit.identify(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := Dependency{
+		ImportPath: "example.com/r",
+		Rev:        rev,
+		repoRoot:   &vcs.RepoRoot{Root: "example.com/r", Repo: remote},
+		vcs:        vcsGit,
+	}
+	gopath, err := sandbox(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadFile(filepath.Join(gopath, "src", "example.com", "r", "c", "lib", "lib.h"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := string(body); g != "int x;\n" {
+		t.Errorf("lib.h = %q want %q", g, "int x;\n")
+	}
+}
+
+func TestSandboxSparse(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir, err := ioutil.TempDir("", "deppygit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(s string) { spool = s }(spool)
+	spool = filepath.Join(dir, "spool")
+
+	gone := filepath.Join(dir, "gone")
+	if err := writeFile(filepath.Join(gone, "g.go"), "package g\n"); err != nil {
+		t.Fatal(err)
+	}
+	run(t, gone, "git", "init", "-q")
+	run(t, gone, "git", "add", ".")
+	run(t, gone, "git", "commit", "-q", "-m", "gone")
+
+	remote := filepath.Join(dir, "remote")
+	files := map[string]string{
+		"a/a.go":             pkg("a", "example.com/r/b"),
+		"a/testdata/x":       "x\n",
+		"b/b.go":             pkg("b"),
+		"c/c.go":             pkg("c"),
+		"c/testdata/y":       "y\n",
+		"c/d/d.go":           pkg("d"),
+		"e/e.go":             pkg("e"),
+		"a/internal/i.go":    pkg("i"),
+		"Deps":               "{}\n",
+		"Godeps/Godeps.json": "{}\n",
+	}
+	for name, body := range files {
+		if err := writeFile(filepath.Join(remote, filepath.FromSlash(name)), body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run(t, remote, "git", "init", "-q")
+	run(t, remote, "git", "add", ".")
+	run(t, remote, "git", "commit", "-q", "-m", "a")
+	rev, err := vcsGit.identify(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := Dependency{
+		ImportPath: "example.com/r/a",
+		Rev:        rev,
+		repoRoot:   &vcs.RepoRoot{Root: "example.com/r", Repo: remote},
+		vcs:        vcsGit,
+		sparseDirs: []string{"a"},
+	}
+	var cases = []struct {
+		dirs []string
+		want []string
+	}{
+		{[]string{"a"}, []string{"a/a.go", "a/testdata/x", "b/b.go", "Deps", "Godeps/Godeps.json"}},
+		{[]string{"a", "c"}, []string{"a/a.go", "a/testdata/x", "b/b.go", "c/c.go", "c/testdata/y", "Deps", "Godeps/Godeps.json"}},
+	}
+	for _, test := range cases {
+		d.sparseDirs = test.dirs
+		gopath, err := sandbox(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src := filepath.Join(gopath, "src", "example.com", "r")
+		for name := range files {
+			want := containsString(test.want, name)
+			if g := exists(filepath.Join(src, filepath.FromSlash(name))); g != want {
+				t.Errorf("dirs %v: %s present = %v want %v", test.dirs, name, g, want)
+			}
+		}
+	}
+}
+
+func TestImportDirs(t *testing.T) {
+	var cases = []struct {
+		dir, path string
+		want      []string
+	}{
+		{"a/b", "fmt", nil},
+		{"a/b", "example.com/r/c", []string{"a/b/vendor/example.com/r/c", "a/vendor/example.com/r/c", "vendor/example.com/r/c", "c"}},
+		{".", "example.com/x", []string{"vendor/example.com/x"}},
+		{"a", "example.com/r", []string{"a/vendor/example.com/r", "vendor/example.com/r", "."}},
+	}
+	for _, test := range cases {
+		g := importDirs("example.com/r", test.dir, test.path)
+		if !reflect.DeepEqual(g, test.want) {
+			t.Errorf("importDirs(%s, %s) = %v want %v", test.dir, test.path, g, test.want)
+		}
+	}
+}
+
+// TestSandboxSparseBuild checks that a package builds in a
+// sparse sandbox when it uses vendored packages and packages
+// in a submodule, and that a failed sparse checkout leaves
+// nothing in the spool.
+func TestSandboxSparseBuild(t *testing.T) {
+	for _, name := range []string{"git", "go"} {
+		if _, err := exec.LookPath(name); err != nil {
+			t.Skipf("%s not installed", name)
+		}
+	}
+	dir, err := ioutil.TempDir("", "deppygit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(s string) { spool = s }(spool)
+	spool = filepath.Join(dir, "spool")
+
+	lib := filepath.Join(dir, "lib")
+	if err := writeFile(filepath.Join(lib, "l", "l.go"), "package l\n\nconst L = 1\n"); err != nil {
+		t.Fatal(err)
+	}
+	run(t, lib, "git", "init", "-q")
+	run(t, lib, "git", "add", ".")
+	run(t, lib, "git", "commit", "-q", "-m", "lib")
+
+	gone := filepath.Join(dir, "gone")
+	if err := writeFile(filepath.Join(gone, "g.go"), "package g\n"); err != nil {
+		t.Fatal(err)
+	}
+	run(t, gone, "git", "init", "-q")
+	run(t, gone, "git", "add", ".")
+	run(t, gone, "git", "commit", "-q", "-m", "gone")
+
+	remote := filepath.Join(dir, "remote")
+	files := map[string]string{
+		"a/a.go":                    "package a\n\nimport (\n\t\"example.com/r/b\"\n\t\"example.com/r/third/lib/l\"\n\t\"example.com/v\"\n)\n\nconst A = b.B + l.L + v.V\n",
+		"b/b.go":                    "package b\n\nconst B = 1\n",
+		"vendor/example.com/v/v.go": "package v\n\nconst V = 1\n",
+		"e/e.go":                    "package e\n\nconst E = 1\n",
+	}
+	for name, body := range files {
+		if err := writeFile(filepath.Join(remote, filepath.FromSlash(name)), body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run(t, remote, "git", "init", "-q")
+	run(t, remote, "git", "-c", "protocol.file.allow=always", "submodule", "--quiet", "add", lib, "third/lib")
+	run(t, remote, "git", "-c", "protocol.file.allow=always", "submodule", "--quiet", "add", gone, "third/gone")
+	run(t, remote, "git", "add", ".")
+	run(t, remote, "git", "commit", "-q", "-m", "a")
+	rev, err := vcsGit.identify(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.RemoveAll(gone)
+
+	d := Dependency{
+		ImportPath: "example.com/r/a",
+		Rev:        rev,
+		repoRoot:   &vcs.RepoRoot{Root: "example.com/r", Repo: remote},
+		vcs:        vcsGit,
+		sparseDirs: []string{"third/gone"},
+	}
+	if _, err := sandbox(d); err == nil {
+		t.Fatal("sandbox with a missing submodule succeeded")
+	}
+	if exists(d.Gopath()) {
+		t.Errorf("failed sparse checkout left %s", d.Gopath())
+	}
+
+	d.sparseDirs = []string{"a"}
+	gopath, err := sandbox(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists(filepath.Join(gopath, "src", "example.com", "r", "e")) {
+		t.Error("sparse checkout has unneeded directory e")
+	}
+	c := exec.Command("go", "build", "example.com/r/a")
+	c.Env = append(envNoGopath(), "GOPATH="+gopath, "GO111MODULE=off", "GOFLAGS=")
+	if out, err := c.CombinedOutput(); err != nil {
+		t.Errorf("go build: %v\n%s", err, out)
+	}
+}
@@ -1,18 +1,25 @@
 package main
 
 import (
+	"crypto/sha1"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
+// spool is the writable directory holding repos and
+// checked-out revisions. It can be set with DEPPY_SPOOL
+// or the Spool config setting.
 var spool = filepath.Join(os.TempDir(), "deppy")
 
 var cmdGo = &Command{
-	Usage: "go command [arguments]",
+	Usage: "go [-merge] command [arguments]",
 	Short: "run the go tool in a sandbox",
 	Long: `
 Go runs the go tool in a temporary GOPATH sandbox
@@ -21,35 +28,65 @@ with the dependencies listed in file Deps.
 Any go tool command can run this way, but "deppy go get"
 is unnecessary and has been disabled. Instead, use
 "deppy go install".
+
+Repos and checked-out revisions are kept in a spool
+directory, by default $TMPDIR/deppy. Set DEPPY_SPOOL or
+the Spool setting in a .deppy config file to use another
+location. Read-only spools, such as a cache prewarmed in
+a CI image, can be listed in DEPPY_READONLY_SPOOL or the
+ReadOnlySpools setting; revisions found there are used
+without fetching anything.
+
+The -merge flag assembles a single GOPATH directory, whose
+src tree is a farm of symlinks into the checked-out
+revisions, instead of using one GOPATH entry per dependency.
+
+Deppy exits with the go tool's exit status. Terminate signals
+are passed on to the go tool; interrupt and quit signals from
+the terminal reach it directly. If any of these arrive while
+the sandbox is being prepared, partly checked-out revisions
+are removed.
 `,
 	Run: runGo,
 }
 
+var sandboxMerge bool
+
+func init() {
+	cmdGo.Flag.BoolVar(&sandboxMerge, "merge", false, "use a single merged GOPATH entry")
+}
+
 // Set up a sandbox and run the go tool. The sandbox is built
 // out of specific checked-out revisions of repos. We keep repos
 // and revs materialized on disk under the assumption that disk
 // space is cheap and plentiful, and writing files is slow.
 // Everything is kept in the spool directory.
 func runGo(cmd *Command, args []string) {
-	gopath := prepareGopath()
-	if s := os.Getenv("GOPATH"); s != "" {
-		gopath += string(os.PathListSeparator) + os.Getenv("GOPATH")
-	}
 	if len(args) > 0 && args[0] == "get" {
 		log.Printf("invalid subcommand: %q", "go get")
 		fmt.Fprintln(os.Stderr, "Use 'deppy go install' instead.")
 		fmt.Fprintln(os.Stderr, "Run 'deppy help go' for usage.")
 		os.Exit(2)
 	}
-	c := exec.Command("go", args...)
+	stop := catchInterrupts()
+	gopath := prepareGopath()
+	stop()
+	runChild(sandboxCommand(gopath, "go", args...))
+}
+
+// sandboxCommand returns a Cmd to run the named program with
+// GOPATH set to gopath followed by the outer GOPATH, and with
+// the standard streams of the current process.
+func sandboxCommand(gopath, name string, args ...string) *exec.Cmd {
+	if s := outerGopath(); s != "" {
+		gopath += string(os.PathListSeparator) + s
+	}
+	c := exec.Command(name, args...)
 	c.Env = append(envNoGopath(), "GOPATH="+gopath)
 	c.Stdin = os.Stdin
 	c.Stdout = os.Stdout
 	c.Stderr = os.Stderr
-	err := c.Run()
-	if err != nil {
-		log.Fatalln("go", err)
-	}
+	return c
 }
 
 // prepareGopath reads dependency information from the filesystem
@@ -58,30 +95,58 @@ func runGo(cmd *Command, args []string) {
 func prepareGopath() (gopath string) {
 	dir := findDeps()
 	if dir == "" {
-		log.Fatalln("No Deps found (or in any parent directory)")
+		log.Fatalf("No %s found (or in any parent directory)", depsFile)
 	}
-	g, err := ReadAndLoadDeps(filepath.Join(dir, "Deps"))
+	gopath, err := loadGopath(depsPath(dir))
 	if err != nil {
-		log.Fatalln(err)
+		fatal(err)
 	}
-	gopath, err = sandboxAll(g.Deps)
+	return gopath
+}
+
+// loadGopath reads the Deps file at path, fetches any
+// necessary code, and returns a gopath causing the specified
+// dependencies to be used.
+func loadGopath(path string) (string, error) {
+	g, err := ReadAndLoadDeps(path)
 	if err != nil {
-		log.Fatalln(err)
+		return "", err
 	}
-	return gopath
+	return sandboxAll(g.Deps)
 }
 
-// findDeps looks for a directory entry "Deps" in the
+// depsFile names the project's manifest. It can be set with
+// flag -deps. A relative name, which may include directories,
+// is looked up in the current directory and its parents.
+var depsFile = "Deps"
+
+// findDeps looks for a directory entry depsFile in the
 // current directory or any parent, and returns the containing
-// directory and whether the entry itself is a directory.
-// If Deps can't be found, findDeps returns "".
+// directory. If depsFile is absolute, the containing directory
+// is taken to be the current directory.
+// If depsFile can't be found, findDeps returns "".
 // For any other error, it exits the program.
 func findDeps() (dir string) {
 	wd, err := os.Getwd()
 	if err != nil {
 		log.Fatalln(err)
 	}
-	return findInParents(wd, "Deps")
+	if filepath.IsAbs(depsFile) {
+		if !exists(depsFile) {
+			return ""
+		}
+		return wd
+	}
+	return findInParents(wd, depsFile)
+}
+
+// depsPath returns the path of depsFile in dir, as returned
+// by findDeps.
+func depsPath(dir string) string {
+	if filepath.IsAbs(depsFile) {
+		return depsFile
+	}
+	return filepath.Join(dir, depsFile)
 }
 
 // isRoot returns true iff a path is a root.
@@ -129,22 +194,166 @@ func envNoGopath() (a []string) {
 // sandboxAll ensures that the commits in deps are available
 // on disk, and returns a GOPATH string that will cause them
 // to be used.
+//
+// In offline mode, it reports every dependency that
+// can't be satisfied locally rather than just the first.
 func sandboxAll(a []Dependency) (gopath string, err error) {
-	var path []string
+	path, err := sandboxDeps(a)
+	if err != nil {
+		return "", err
+	}
+	if sandboxMerge {
+		return mergeGopath(a, path)
+	}
+	return strings.Join(path, ":"), nil
+}
+
+// sandboxDeps ensures that the commits in deps are available
+// on disk, and returns the GOPATH directory holding each one.
+// Offline, it reports every missing commit, as a failure.
+func sandboxDeps(a []Dependency) (gopaths []string, err error) {
+	var missing offlineError
+	for i := range a {
+		if root := a[i].repoRoot.Root; config.sparse(root) {
+			a[i].sparseDirs = repoDirs(a, root)
+		}
+	}
 	for _, dep := range a {
 		dir, err := sandbox(dep)
+		if err != nil && offline {
+			if jsonOutput {
+				emit(Event{Action: "fail", ImportPath: dep.ImportPath, Rev: dep.Rev, Error: err.Error()})
+			}
+			missing = append(missing, dep.ImportPath+" "+dep.Rev+": "+err.Error())
+			continue
+		}
 		if err != nil {
+			return nil, err
+		}
+		gopaths = append(gopaths, dir)
+	}
+	if missing != nil {
+		return nil, failure{missing}
+	}
+	return gopaths, nil
+}
+
+// mergeGopath assembles a single GOPATH directory whose src
+// tree is a farm of symlinks into the checked-out repo roots
+// of deps, found in the corresponding gopaths. The directory
+// is named after a hash of its contents, so it is built once
+// and reused by every sandbox with the same dependencies.
+// A repo root nested inside another is linked in place of the
+// outer repo's directory of the same name.
+func mergeGopath(deps []Dependency, gopaths []string) (string, error) {
+	links := make(map[string]string) // repo root -> checkout
+	for i, d := range deps {
+		links[d.repoRoot.Root] = filepath.Join(gopaths[i], "src", d.repoRoot.Root)
+	}
+	var roots []string
+	for root := range links {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+	h := sha1.New()
+	for _, root := range roots {
+		fmt.Fprintf(h, "%s %s\n", root, links[root])
+	}
+	parent := filepath.Join(spool, "gopath")
+	dir := filepath.Join(parent, fmt.Sprintf("%x", h.Sum(nil)))
+	if exists(dir) {
+		return dir, nil
+	}
+	if err := os.MkdirAll(parent, 0777); err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempDir(parent, "tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	for _, root := range roots {
+		var nested []string
+		for _, r := range roots {
+			if strings.HasPrefix(r, root+"/") {
+				nested = append(nested, r[len(root)+1:])
+			}
+		}
+		name := filepath.Join(tmp, "src", filepath.FromSlash(root))
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			return "", err
+		}
+		if err := linkTree(name, links[root], nested); err != nil {
 			return "", err
 		}
-		path = append(path, dir)
 	}
-	return strings.Join(path, ":"), nil
+	if err := os.Rename(tmp, dir); err != nil && !exists(dir) {
+		return "", err
+	}
+	return dir, nil
+}
+
+// linkTree makes name a symlink to target. If nested, a list
+// of slash-separated paths below target, is not empty, name is
+// instead a directory of links to the entries of target, built
+// the same way, leaving out the nested paths themselves.
+func linkTree(name, target string, nested []string) error {
+	if len(nested) == 0 {
+		return os.Symlink(target, name)
+	}
+	below := make(map[string][]string) // first element -> rest
+	skip := make(map[string]bool)
+	for _, p := range nested {
+		elem, rest := p, ""
+		if i := strings.Index(p, "/"); i >= 0 {
+			elem, rest = p[:i], p[i+1:]
+		}
+		if rest == "" {
+			skip[elem] = true
+		} else {
+			below[elem] = append(below[elem], rest)
+		}
+	}
+	if err := os.MkdirAll(name, 0777); err != nil {
+		return err
+	}
+	fis, err := ioutil.ReadDir(target)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		elem := fi.Name()
+		if skip[elem] {
+			continue
+		}
+		err := linkTree(filepath.Join(name, elem), filepath.Join(target, elem), below[elem])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // sandbox ensures that commit d is available on disk,
 // and returns a GOPATH string that will cause it to be used.
 func sandbox(d Dependency) (gopath string, err error) {
+	if err := d.checkRev(); err != nil {
+		return "", err
+	}
+	if d.checkedOut(d.Gopath()) {
+		return d.Gopath(), nil
+	}
+	if dir := d.readOnlyGopath(); dir != "" {
+		return dir, nil
+	}
+	if d.vcs == nil {
+		return "", errors.New("no local repo")
+	}
+	logv("%s: checking out %s", d.ImportPath, d.Rev)
 	if !exists(d.RepoPath()) {
+		if offline && d.FastRemotePath() == "" {
+			return "", errors.New("no local repo")
+		}
 		if err = d.CreateRepo("fast", "main"); err != nil {
 			return "", fmt.Errorf("create repo: %s", err)
 		}
@@ -153,8 +362,17 @@ func sandbox(d Dependency) (gopath string, err error) {
 	if err != nil && d.FastRemotePath() != "" {
 		err = d.fetchAndCheckout("fast")
 	}
-	if err != nil {
-		err = d.fetchAndCheckout("main")
+	// Link the remotes again before fetching, so changes to
+	// the rewrite rules and mirrors apply to existing repos.
+	for _, r := range d.remotes() {
+		if err == nil || offline {
+			break
+		}
+		if err = d.link(r.name, r.url); err != nil {
+			continue
+		}
+		logv("%s: fetching %s from %s", d.ImportPath, d.Rev, r.url)
+		err = d.fetchAndCheckout(r.name)
 	}
 	if err != nil {
 		return "", err
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestMergeGopath(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "deppymerge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	defer func(s string) { spool = s }(spool)
+	spool = filepath.Join(tmp, "spool")
+
+	rev1 := filepath.Join(tmp, "rev1")
+	rev2 := filepath.Join(tmp, "rev2")
+	writeFile(filepath.Join(rev1, "src", "example.com", "a", "a.go"), "package a")
+	writeFile(filepath.Join(rev1, "src", "example.com", "a", "b", "c", "old.go"), "package c")
+	writeFile(filepath.Join(rev1, "src", "example.com", "a", "b", "d", "d.go"), "package d")
+	writeFile(filepath.Join(rev2, "src", "example.com", "a", "b", "c", "new.go"), "package c")
+	writeFile(filepath.Join(rev2, "src", "example.com", "x", "x.go"), "package x")
+
+	dep := func(root string) Dependency {
+		return Dependency{ImportPath: root, repoRoot: &vcs.RepoRoot{Root: root}}
+	}
+	deps := []Dependency{dep("example.com/a"), dep("example.com/a/b/c"), dep("example.com/x")}
+	dir, err := mergeGopath(deps, []string{rev1, rev2, rev2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := filepath.Join(dir, "src", "example.com")
+	var cases = []struct {
+		path string // below src
+		link string // symlink target, or "" for a directory
+	}{
+		{"x", filepath.Join(rev2, "src", "example.com", "x")},
+		{"a", ""},
+		{"a/a.go", filepath.Join(rev1, "src", "example.com", "a", "a.go")},
+		{"a/b", ""},
+		{"a/b/d", filepath.Join(rev1, "src", "example.com", "a", "b", "d")},
+		{"a/b/c", filepath.Join(rev2, "src", "example.com", "a", "b", "c")},
+	}
+	for _, test := range cases {
+		name := filepath.Join(src, filepath.FromSlash(test.path))
+		fi, err := os.Lstat(name)
+		if err != nil {
+			t.Errorf("%s: %v", test.path, err)
+			continue
+		}
+		if test.link == "" {
+			if !fi.IsDir() {
+				t.Errorf("%s: mode %v, want directory", test.path, fi.Mode())
+			}
+			continue
+		}
+		if g, err := os.Readlink(name); err != nil || g != test.link {
+			t.Errorf("%s -> %q, %v want %q", test.path, g, err, test.link)
+		}
+	}
+	if exists(filepath.Join(src, "a", "b", "c", "old.go")) {
+		t.Error("outer repo's copy of nested root is visible")
+	}
+
+	again, err := mergeGopath(deps, []string{rev1, rev2, rev2})
+	if err != nil || again != dir {
+		t.Errorf("second mergeGopath = %s, %v want %s", again, err, dir)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+var cmdInstall = &Command{
+	Usage: "install importpath@version...",
+	Short: "build and install a command at a pinned version",
+	Long: `
+Install builds the command named by each import path, at the given
+version (a revision ID, tag, or branch), and puts the binary in
+GOBIN, or in the bin directory of the first GOPATH entry.
+
+The command is fetched into the spool used by 'deppy go'. If its
+repo has a Deps file, the command is built with the dependencies
+listed there, checked out in the spool as well. Otherwise its
+dependencies are downloaded as by go get. Either way, the build
+uses a temporary GOPATH, and $GOPATH is neither used nor changed.
+`,
+	Run: runInstall,
+}
+
+func runInstall(cmd *Command, args []string) {
+	if len(args) == 0 {
+		cmd.UsageExit()
+	}
+	for _, arg := range args {
+		if !strings.Contains(arg, "@") {
+			cmd.UsageExit()
+		}
+	}
+	bin, err := gobin()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	for _, arg := range args {
+		if err := install(arg, bin); err != nil {
+			fatal(err)
+		}
+	}
+}
+
+// install builds the command importpath@version given in arg
+// into directory bin, using a temporary GOPATH made from the
+// sandbox checkouts of the command and its dependencies.
+func install(arg, bin string) error {
+	d, gopath, err := pinnedDep(arg, config.Resolve)
+	if err != nil {
+		return err
+	}
+	if err := build(d, gopath, bin); err != nil {
+		return fmt.Errorf("%s: %s", arg, err)
+	}
+	return nil
+}
+
+// build builds command d, checked out in gopath, into bin.
+func build(d Dependency, gopath, bin string) error {
+	tmp, err := ioutil.TempDir("", "deppy-install")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	list := []string{tmp, gopath}
+
+	src := filepath.Join(gopath, "src") + string(filepath.Separator)
+	manifest := pkgDeps(filepath.Join(gopath, "src", filepath.FromSlash(d.ImportPath)))
+	hasDeps := strings.HasPrefix(manifest, src)
+	if hasDeps {
+		g, err := ReadAndLoadDeps(manifest)
+		if err != nil {
+			return err
+		}
+		gopaths, err := sandboxDeps(g.Deps)
+		if err != nil {
+			return err
+		}
+		list = append(list, gopaths...)
+	}
+
+	env := append(envNoGopath(), "GOPATH="+strings.Join(list, string(filepath.ListSeparator)))
+	if !hasDeps && !offline {
+		c := command("go", "get", "-d", d.ImportPath)
+		c.Env = env
+		c.Stdout = os.Stdout
+		if err := runCmd(c); err != nil {
+			return err
+		}
+	}
+	name := path.Base(d.ImportPath)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	c := command("go", "build", "-o", filepath.Join(bin, name), d.ImportPath)
+	c.Env = env
+	c.Stdout = os.Stdout
+	return runCmd(c)
+}
+
+// gobin returns the directory go install puts commands in.
+func gobin() (string, error) {
+	if s := os.Getenv("GOBIN"); s != "" {
+		return s, nil
+	}
+	out, err := outputCmd(exec.Command("go", "env", "GOPATH"))
+	if err != nil {
+		return "", err
+	}
+	gopath := filepath.SplitList(strings.TrimSpace(string(out)))
+	if len(gopath) == 0 {
+		return "", errors.New("GOPATH not set")
+	}
+	return filepath.Join(gopath[0], "bin"), nil
+}
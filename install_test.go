@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestInstall(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir, err := ioutil.TempDir("", "deppyinstall")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(s string) { spool = s }(spool)
+	spool = filepath.Join(dir, "spool")
+	defer func(r []Resolution) { config.Resolve = r }(config.Resolve)
+	defer os.Setenv("GOPATH", os.Getenv("GOPATH"))
+	gopath := filepath.Join(dir, "gopath")
+	if err := os.MkdirAll(gopath, 0777); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("GOPATH", gopath)
+
+	lib := filepath.Join(dir, "lib")
+	if err := writeFile(filepath.Join(lib, "lib.go"), "package lib\n\nconst X = \"one\"\n"); err != nil {
+		t.Fatal(err)
+	}
+	run(t, lib, "git", "init", "-q")
+	run(t, lib, "git", "add", ".")
+	run(t, lib, "git", "commit", "-q", "-m", "one")
+	rev, err := vcsGit.identify(lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(filepath.Join(lib, "lib.go"), "package lib\n"); err != nil {
+		t.Fatal(err)
+	}
+	run(t, lib, "git", "commit", "-q", "-a", "-m", "two")
+
+	cmd := filepath.Join(dir, "cmd")
+	main := "package main\n\nimport (\n\t\"fmt\"\n\t\"example.com/lib\"\n)\n\nfunc main() { fmt.Print(lib.X) }\n"
+	deps := `{"ImportPath": "example.com/cmd", "Deps": [{"ImportPath": "example.com/lib", "Rev": "` + rev + `"}]}`
+	if err := writeFile(filepath.Join(cmd, "main.go"), main); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(filepath.Join(cmd, "Deps"), deps); err != nil {
+		t.Fatal(err)
+	}
+	run(t, cmd, "git", "init", "-q")
+	run(t, cmd, "git", "add", ".")
+	run(t, cmd, "git", "commit", "-q", "-m", "cmd")
+	run(t, cmd, "git", "tag", "v1")
+
+	config.Resolve = []Resolution{
+		{ImportPath: "example.com/cmd", VCS: "git", Repo: cmd},
+		{ImportPath: "example.com/lib", VCS: "git", Repo: lib},
+	}
+	bin := filepath.Join(dir, "bin")
+	if err := install("example.com/cmd@v1", bin); err != nil {
+		t.Fatal(err)
+	}
+	if g := run(t, dir, filepath.Join(bin, "cmd")); g != "one" {
+		t.Errorf("cmd printed %q want %q", g, "one")
+	}
+	if fis, _ := ioutil.ReadDir(gopath); len(fis) != 0 {
+		t.Errorf("GOPATH changed: has %d entries", len(fis))
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// jsonOutput is set by flag -json. Commands then write
+// Events to stdout instead of text.
+var jsonOutput bool
+
+// An Event is one line of output with flag -json. Action says
+// what happened; the other fields are set as they apply.
+//
+//	save      ImportPath was recorded at Rev
+//	get       ImportPath was fetched at Rev and recorded
+//	path      Path is the sandbox GOPATH
+//	plan      restore -n will change Root from OldRev to Rev
+//	restore   Root was checked out at Rev
+//	stash     changes in Dir were stashed
+//	rollback  Root was put back to Rev
+//	conflict  By, in manifest file Manifest, wants Root at Want, not Rev
+//	fail      updating Root or ImportPath failed with Error
+//	warning   Error is a warning
+//	error     Error is any other error
+type Event struct {
+	Action     string
+	ImportPath string   `json:",omitempty"`
+	Root       string   `json:",omitempty"`
+	Rev        string   `json:",omitempty"`
+	OldRev     string   `json:",omitempty"`
+	Comment    string   `json:",omitempty"`
+	Dir        string   `json:",omitempty"`
+	Path       string   `json:",omitempty"`
+	By         string   `json:",omitempty"`
+	Manifest   string   `json:",omitempty"`
+	Want       string   `json:",omitempty"`
+	Notes      []string `json:",omitempty"`
+	Error      string   `json:",omitempty"`
+}
+
+var (
+	eventMu  sync.Mutex
+	eventOut io.Writer = os.Stdout
+)
+
+// emit writes e to stdout as a line of JSON.
+func emit(e Event) {
+	eventMu.Lock()
+	defer eventMu.Unlock()
+	json.NewEncoder(eventOut).Encode(e)
+}
+
+// report emits e with flag -json, and otherwise logs a
+// message formatted as by log.Printf.
+func report(e Event, format string, args ...interface{}) {
+	if jsonOutput {
+		emit(e)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// A failure is an error that has already been emitted as a
+// fail Event.
+type failure struct{ error }
+
+// fatal is like log.Fatalln(err), but with flag -json it does
+// not repeat a failure as an error Event.
+func fatal(err error) {
+	if _, ok := err.(failure); ok && jsonOutput {
+		os.Exit(1)
+	}
+	log.Fatalln(err)
+}
+
+// A jsonLog turns each message written by package log into an
+// error Event, or a warning Event if it starts with "warning:".
+type jsonLog struct{}
+
+func (jsonLog) Write(p []byte) (int, error) {
+	msg := strings.TrimSpace(string(p))
+	e := Event{Action: "error", Error: msg}
+	if strings.HasPrefix(msg, "warning:") {
+		e = Event{Action: "warning", Error: strings.TrimSpace(msg[len("warning:"):])}
+	}
+	emit(e)
+	return len(p), nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestJSONOutput(t *testing.T) {
+	var buf bytes.Buffer
+	eventOut = &buf
+	defer func() { eventOut = os.Stdout }()
+	jsonOutput = true
+	defer func() { jsonOutput = false }()
+
+	l := log.New(jsonLog{}, "", 0)
+	l.Println("warning: dependencies pin other revisions:")
+	l.Println("No Deps found")
+	log.SetOutput(jsonLog{})
+	log.SetFlags(0)
+	warn("ignoring stdlib package:", "fmt")
+	log.SetOutput(os.Stderr)
+	log.SetFlags(log.LstdFlags)
+	report(Event{Action: "restore", Root: "example.com/a", Rev: "abc"}, "restore: %s", "ignored")
+	printConflicts(nil, []conflict{{
+		Root: "example.com/a",
+		Rev:  "abc",
+		By:   &manifest{Root: "example.com/b", Name: "Deps"},
+		Want: "def",
+	}})
+
+	want := `{"Action":"warning","Error":"dependencies pin other revisions:"}
+{"Action":"error","Error":"No Deps found"}
+{"Action":"warning","Error":"ignoring stdlib package: fmt"}
+{"Action":"restore","Root":"example.com/a","Rev":"abc"}
+{"Action":"conflict","Root":"example.com/a","Rev":"abc","By":"example.com/b","Manifest":"Deps","Want":"def"}
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -55,16 +55,41 @@ func (c *Command) UsageExit() {
 var commands = []*Command{
 	cmdSave,
 	cmdGo,
+	cmdExec,
 	cmdGet,
+	cmdInstall,
 	cmdPath,
+	cmdEnv,
+	cmdHook,
 	cmdRestore,
+	cmdConflicts,
 }
 
 func main() {
+	var chdir string
 	flag.Usage = usageExit
+	flag.StringVar(&chdir, "C", "", "change to `dir` before doing anything else")
+	flag.StringVar(&depsFile, "deps", depsFile, "use manifest `file` instead of Deps")
+	flag.BoolVar(&offline, "offline", offline, "forbid network access")
+	flag.BoolVar(&jsonOutput, "json", false, "print output as JSON")
+	flag.BoolVar(&verboseOutput, "v", false, "report progress for each dependency")
+	flag.BoolVar(&traceCommands, "x", false, "print commands as they finish")
+	flag.BoolVar(&quietOutput, "q", false, "print only errors")
 	flag.Parse()
+	if verboseOutput && quietOutput {
+		usageExit()
+	}
 	log.SetFlags(0)
 	log.SetPrefix("deppy: ")
+	if jsonOutput {
+		log.SetPrefix("")
+		log.SetOutput(jsonLog{})
+	}
+	if chdir != "" {
+		if err := os.Chdir(chdir); err != nil {
+			log.Fatalln(err)
+		}
+	}
 	args := flag.Args()
 	if len(args) < 1 {
 		usageExit()
@@ -75,6 +100,10 @@ func main() {
 		return
 	}
 
+	if err := loadConfig(); err != nil {
+		log.Fatalln("config:", err)
+	}
+
 	for _, cmd := range commands {
 		if cmd.Name() == args[0] {
 			cmd.Flag.Usage = func() { cmd.UsageExit() }
@@ -94,11 +123,35 @@ Deppy is a tool for managing Go package dependencies.
 
 Usage:
 
-	deppy command [arguments]
+	deppy [-C dir] [-deps file] [-offline] [-json] [-v | -q] [-x] command [arguments]
+
+The -C flag changes to dir before running the command.
+
+The -deps flag names the project's manifest, instead of Deps,
+for every command. A relative name such as Deps.release or ci/Deps
+is looked up in the current directory and its parents; the
+project is the directory it is found in. Save is the exception:
+it writes the file relative to the current directory, as it does
+Deps. Manifests of other projects are still read from Deps,
+unless they have the named file too.
+
+The -offline flag (or DEPPY_OFFLINE=1) forbids network access.
+Dependencies are then resolved only from GOPATH and the spool.
+
+The -json flag makes save, get, path, env, restore, and conflicts
+print a JSON object per line on stdout for each dependency
+processed or action taken, and turns errors and warnings into
+JSON objects too. See the Event type in the source for the fields.
+
+The -v flag reports progress for each dependency, and shows the
+output of every failing VCS command. The -x flag prints each VCS
+and go command when it finishes, with its directory, GOPATH, and
+running time. The -q flag prints only errors; it can't be used
+with -v, and doesn't change the output of -json.
 
 The commands are:
 {{range .}}
-    {{.Name | printf "%-8s"}} {{.Short}}{{end}}
+    {{.Name | pad}} {{.Short}}{{end}}
 
 Use "deppy help [command]" for more information about a command.
 `
@@ -136,11 +189,24 @@ func printUsage(w io.Writer) {
 	tmpl(w, usageTemplate, commands)
 }
 
+// padName pads a command name to line up the
+// descriptions in the command list.
+func padName(name string) string {
+	w := 0
+	for _, cmd := range commands {
+		if n := len(cmd.Name()); n > w {
+			w = n
+		}
+	}
+	return fmt.Sprintf("%-*s", w+1, name)
+}
+
 // tmpl executes the given template text on data, writing the result to w.
 func tmpl(w io.Writer, text string, data interface{}) {
 	t := template.New("top")
 	t.Funcs(template.FuncMap{
 		"trim": strings.TrimSpace,
+		"pad":  padName,
 	})
 	template.Must(t.Parse(strings.TrimSpace(text) + "\n\n"))
 	if err := t.Execute(w, data); err != nil {
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestUsageAligned(t *testing.T) {
+	var buf bytes.Buffer
+	printUsage(&buf)
+	col := -1
+	for _, cmd := range commands {
+		i := strings.Index(buf.String(), "    "+cmd.Name()+" ")
+		if i < 0 {
+			t.Errorf("%s not listed", cmd.Name())
+			continue
+		}
+		line := buf.String()[i:]
+		line = line[:strings.Index(line, "\n")]
+		c := strings.Index(line, cmd.Short)
+		if col < 0 {
+			col = c
+		}
+		if c != col || c < len("    "+cmd.Name()+" ") {
+			t.Errorf("%q: description at column %d, want %d", line, c, col)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// An ancestryVCS can compare revisions in a sandbox repo.
+// If it can't for a particular repo, it returns errNoAncestry.
+type ancestryVCS interface {
+	isAncestor(dir, a, b string) (bool, error)
+}
+
+var errNoAncestry = errors.New("ancestry checks not supported")
+
+// mergeNested raises the revision of each repo in deps to
+// the newest one required by deps or by the manifests of
+// deps at those revisions, until they agree. It fails if
+// two requirements are on divergent branches. The deps
+// must be ready for sandbox.
+func mergeNested(deps []Dependency) error {
+	for {
+		gopaths, err := sandboxDeps(deps)
+		if err != nil {
+			return err
+		}
+		pins := make(map[string]string)
+		dirs := make(map[string]string)
+		for i, d := range deps {
+			pins[d.repoRoot.Root] = d.Rev
+			dirs[d.repoRoot.Root] = filepath.Join(gopaths[i], "src", filepath.FromSlash(d.repoRoot.Root))
+		}
+		ms, err := readManifests(dirs)
+		if err != nil {
+			return err
+		}
+		cs := findConflicts(pins, ms)
+		changed := false
+		var errs []string
+		for len(cs) > 0 {
+			// cs is sorted by root
+			n := 1
+			for n < len(cs) && cs[n].Root == cs[0].Root {
+				n++
+			}
+			root := cs[0].Root
+			rev, err := newestRev(repoDep(deps, root), cs[:n])
+			cs = cs[n:]
+			if err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			if rev == pins[root] {
+				continue
+			}
+			for i := range deps {
+				if deps[i].repoRoot.Root == root {
+					deps[i].Rev = rev
+					deps[i].Comment = deps[i].vcs.describe(deps[i].RepoPath(), rev)
+				}
+			}
+			changed = true
+		}
+		if errs != nil {
+			return errors.New(strings.Join(errs, "\n"))
+		}
+		if !changed {
+			return nil
+		}
+	}
+}
+
+// newestRev returns whichever of d.Rev and the revisions
+// wanted in cs, all for d's repo, has all the others as
+// ancestors.
+func newestRev(d Dependency, cs []conflict) (string, error) {
+	av, ok := d.vcs.(ancestryVCS)
+	if !ok {
+		return "", fmt.Errorf("%s: %s", d.repoRoot.Root, errNoAncestry)
+	}
+	if err := d.fetchRev(); err != nil {
+		return "", fmt.Errorf("%s: %s: %s", d.repoRoot.Root, d.Rev, err)
+	}
+	best, bestBy := d.Rev, "Deps"
+	for _, c := range cs {
+		e := d
+		e.Rev = c.Want
+		if err := e.fetchRev(); err != nil {
+			return "", fmt.Errorf("%s: %s wanted by %s: %s", c.Root, c.Want, c.By.Root, err)
+		}
+		by := c.By.Root + " (" + c.By.Name + ")"
+		newer, err := av.isAncestor(d.RepoPath(), best, c.Want)
+		if err != nil {
+			return "", fmt.Errorf("%s: %s", c.Root, err)
+		}
+		if newer {
+			best, bestBy = c.Want, by
+			continue
+		}
+		older, err := av.isAncestor(d.RepoPath(), c.Want, best)
+		if err != nil {
+			return "", fmt.Errorf("%s: %s", c.Root, err)
+		}
+		if !older {
+			return "", fmt.Errorf("%s: divergent revisions:\n\t%s wanted by %s\n\t%s wanted by %s", c.Root, best, bestBy, c.Want, by)
+		}
+	}
+	return best, nil
+}
+
+// repoDep returns the first of deps in the repo at root.
+func repoDep(deps []Dependency, root string) Dependency {
+	for _, d := range deps {
+		if d.repoRoot.Root == root {
+			return d
+		}
+	}
+	panic("no dependency in " + root)
+}
+
+// fetchRev makes sure d.Rev is in d's sandbox repo,
+// fetching it from the fast remote or the others.
+func (d Dependency) fetchRev() error {
+	if !exists(d.RepoPath()) {
+		if err := d.CreateRepo("fast", "main"); err != nil {
+			return err
+		}
+	}
+	if d.vcs.exists(d.RepoPath(), d.Rev) {
+		return nil
+	}
+	remotes := d.remotes()
+	if d.FastRemotePath() != "" {
+		remotes = append([]remote{{"fast", d.FastRemotePath()}}, remotes...)
+	}
+	for _, r := range remotes {
+		if r.url == "" || offline && r.name != "fast" {
+			continue
+		}
+		if err := d.link(r.name, r.url); err != nil {
+			continue
+		}
+		if d.fetch(r.name) == nil && d.vcs.exists(d.RepoPath(), d.Rev) {
+			return nil
+		}
+	}
+	return errors.New("revision not found")
+}
+
+// prepareSandbox fills in the fields sandbox needs for deps
+// found in GOPATH by Load, using the remote of each working
+// copy.
+func prepareSandbox(deps []Dependency) {
+	for i := range deps {
+		d := &deps[i]
+		dir := filepath.Join(d.ws, "src", filepath.FromSlash(d.root))
+		d.repoRoot = &vcs.RepoRoot{Root: d.root, Repo: d.vcs.remoteURL(dir)}
+		d.outerRoot = d.ws
+	}
+}
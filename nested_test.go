@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestMergeNested(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir, err := ioutil.TempDir("", "deppynested")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(s string) { spool = s }(spool)
+	spool = filepath.Join(dir, "spool")
+
+	commit := func(repo, msg string) string {
+		run(t, repo, "git", "commit", "-q", "--allow-empty", "-m", msg)
+		rev, err := vcsGit.identify(repo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rev
+	}
+	lib := filepath.Join(dir, "lib")
+	if err := writeFile(filepath.Join(lib, "lib.go"), pkg("lib")); err != nil {
+		t.Fatal(err)
+	}
+	run(t, lib, "git", "init", "-q")
+	run(t, lib, "git", "add", ".")
+	c1 := commit(lib, "one")
+	c2 := commit(lib, "two")
+	run(t, lib, "git", "checkout", "-q", "-b", "side", c1)
+	c3 := commit(lib, "three")
+
+	// manifestRepo makes a repo whose Deps file pins lib at rev.
+	manifestRepo := func(name, rev string) Dependency {
+		repo := filepath.Join(dir, name)
+		body := `{"ImportPath": "example.com/` + name + `", "Deps": [{"ImportPath": "example.com/lib", "Rev": "` + rev + `"}]}`
+		if err := writeFile(filepath.Join(repo, "Deps"), body); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeFile(filepath.Join(repo, name+".go"), pkg(name)); err != nil {
+			t.Fatal(err)
+		}
+		run(t, repo, "git", "init", "-q")
+		run(t, repo, "git", "add", ".")
+		return gitDep("example.com/"+name, repo, commit(repo, "x"))
+	}
+	x := manifestRepo("x", c2)
+	y := manifestRepo("y", c3)
+	z := manifestRepo("z", c1)
+
+	deps := []Dependency{gitDep("example.com/lib", lib, c1), x, z}
+	if err := mergeNested(deps); err != nil {
+		t.Fatal(err)
+	}
+	if deps[0].Rev != c2 {
+		t.Errorf("lib rev = %s want %s", deps[0].Rev, c2)
+	}
+
+	deps = []Dependency{gitDep("example.com/lib", lib, c1), x, y}
+	err = mergeNested(deps)
+	if err == nil || !strings.Contains(err.Error(), "divergent") {
+		t.Errorf("mergeNested err = %v, want divergent revisions", err)
+	}
+}
+
+// gitDep returns a dependency on the git repo at path repo.
+func gitDep(importPath, repo, rev string) Dependency {
+	return Dependency{
+		ImportPath: importPath,
+		Rev:        rev,
+		repoRoot:   &vcs.RepoRoot{Root: importPath, Repo: repo},
+		vcs:        vcsGit,
+	}
+}
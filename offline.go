@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// offline forbids all network access. Dependencies are
+// resolved only from the outer GOPATH and the spools.
+// It is set by flag -offline or DEPPY_OFFLINE=1.
+var offline bool
+
+func init() {
+	offline, _ = strconv.ParseBool(os.Getenv("DEPPY_OFFLINE"))
+}
+
+// offlineError lists the dependencies that cannot be
+// satisfied without network access, one per element.
+type offlineError []string
+
+func (e offlineError) Error() string {
+	return "not available offline:\n\t" + strings.Join(e, "\n\t")
+}
+
+// resolveOffline finds the VCS and repo root for d using
+// only local information. It looks for a clone in the outer
+// GOPATH, then for a repo in the spool, and finally for an
+// existing checkout of d.Rev in any spool. In the last case
+// the returned VCS is nil; the checkout can be used as is
+// but nothing can be fetched into it.
+func (d *Dependency) resolveOffline() (VCS, *vcs.RepoRoot, error) {
+	if d.outerRoot != "" {
+		src := filepath.Join(d.outerRoot, "src")
+		dir := filepath.Join(src, filepath.FromSlash(d.ImportPath))
+		v, root, err := VCSFromDir(dir, src)
+		if err == nil {
+			url := v.remoteURL(filepath.Join(src, filepath.FromSlash(root)))
+			return v, &vcs.RepoRoot{Repo: url, Root: root}, nil
+		}
+	}
+	for p := d.ImportPath; p != "." && p != "/"; p = path.Dir(p) {
+		if v := spoolVCS(filepath.Join(spool, "repo", filepath.FromSlash(p))); v != nil {
+			return v, &vcs.RepoRoot{Root: p}, nil
+		}
+	}
+	spools := append([]string{spool}, config.ReadOnlySpools...)
+	for _, dir := range spools {
+		gopath := revGopath(dir, d.Rev)
+		if exists(filepath.Join(gopath, "src", filepath.FromSlash(d.ImportPath))) {
+			return nil, &vcs.RepoRoot{Root: d.ImportPath}, nil
+		}
+	}
+	return nil, nil, errors.New(d.ImportPath + " " + d.Rev + ": no local copy")
+}
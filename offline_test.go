@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveOffline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deppyspool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(s string) { spool = s }(spool)
+	spool = dir
+
+	err = os.MkdirAll(filepath.Join(dir, "repo", "example.com", "r", ".hg"), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.MkdirAll(filepath.Join(dir, "rev", "ab", "cd", "src", "example.com", "c", "p"), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cases = []struct {
+		importPath string
+		rev        string
+		vcs        VCS
+		root       string
+		werr       bool
+	}{
+		{"example.com/r/p", "1234", vcsHg, "example.com/r", false},
+		{"example.com/c/p", "abcd", nil, "example.com/c/p", false},
+		{"example.com/c/p", "abce", nil, "", true},
+		{"example.com/x", "abcd", nil, "", true},
+	}
+	for _, test := range cases {
+		d := &Dependency{ImportPath: test.importPath, Rev: test.rev}
+		v, rr, err := d.resolveOffline()
+		if g := err != nil; g != test.werr {
+			t.Errorf("resolveOffline(%s) err = %v want %v", test.importPath, err, test.werr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if v != test.vcs || rr.Root != test.root {
+			t.Errorf("resolveOffline(%s) = %v, %s want %v, %s", test.importPath, v, rr.Root, test.vcs, test.root)
+		}
+	}
+}
@@ -5,7 +5,7 @@ import (
 )
 
 var cmdPath = &Command{
-	Usage: "path",
+	Usage: "path [-merge]",
 	Short: "print sandbox path for use in a GOPATH",
 	Long: `
 Path ensures a sandbox is prepared for the dependencies
@@ -15,16 +15,28 @@ that makes available the specified version of each dependency.
 The printed path does not include any GOPATH value from
 the environment.
 
+The -merge flag prints a single directory, whose src tree
+is a farm of symlinks into the checked-out revisions,
+instead of one path entry per dependency.
+
 For more about how GOPATH works, see 'go help gopath'.
 `,
 	Run: runPath,
 }
 
+func init() {
+	cmdPath.Flag.BoolVar(&sandboxMerge, "merge", false, "print a single merged GOPATH entry")
+}
+
 // Set up a sandbox and print the resulting gopath.
 func runPath(cmd *Command, args []string) {
 	if len(args) != 0 {
 		cmd.UsageExit()
 	}
 	gopath := prepareGopath()
+	if jsonOutput {
+		emit(Event{Action: "path", Path: gopath})
+		return
+	}
 	fmt.Println(gopath)
 }
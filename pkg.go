@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"time"
 )
 
 // Package represents a go source code package
@@ -29,15 +30,18 @@ type Package struct {
 	}
 }
 
-// LoadPackages loads the named packages using go list -json.
-// Unlike the go tool, an empty argument list is treated as
-// an empty list; "." must be given explicitly if desired.
+// LoadPackages loads the named packages using go list -json,
+// in the outer GOPATH. Unlike the go tool, an empty argument
+// list is treated as an empty list; "." must be given
+// explicitly if desired.
 func LoadPackages(name ...string) (a []*Package, err error) {
 	if len(name) == 0 {
 		return nil, nil
 	}
 	args := []string{"list", "-e", "-json"}
 	cmd := exec.Command("go", append(args, name...)...)
+	cmd.Env = append(envNoGopath(), "GOPATH="+outerGopath())
+	start := time.Now()
 	r, err := cmd.StdoutPipe()
 	if err != nil {
 		return nil, err
@@ -60,6 +64,7 @@ func LoadPackages(name ...string) (a []*Package, err error) {
 		a = append(a, info)
 	}
 	err = cmd.Wait()
+	traceCmd(cmd, start, err)
 	if err != nil {
 		return nil, err
 	}
@@ -1,61 +1,483 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 )
 
 var cmdRestore = &Command{
-	Usage: "restore",
+	Usage: "restore [-n] [-force] [-stash] [-p n] [-into dir | -gopath dir]",
 	Short: "check out listed dependency versions in GOPATH",
 	Long: `
 Restore checks out the Deps-specified version of each package in GOPATH.
+
+Restore first finds and downloads any missing packages and works out
+the revision change for each repo, up to n at a time (flag -p,
+default the number of CPUs). If that fails for any package, nothing
+is changed. It then updates the repos, again up to n at a time. If
+any update fails, every repo restore has touched is put back to the
+revision, and for git and hg the branch or bookmark, it had before.
+
+The -n flag prints the planned revision change for each repo,
+without downloading or changing anything.
+
+Restore refuses to change the revision of a repo with uncommitted
+changes, or whose current branch has commits not pushed to any
+remote. The -stash flag stashes uncommitted changes first (git and
+hg only); they are restored if restore rolls back. The -force flag
+disables both checks.
+
+Packages not already in GOPATH are downloaded into its first entry,
+or into the entry given by flag -into.
+
+The -gopath flag instead builds a new, self-contained GOPATH in dir,
+holding a copy of the project and the Deps-specified version of each
+dependency. It uses the checkouts made by 'deppy go' (fetching them
+into the spool if needed) and leaves $GOPATH alone. The dir must not
+already have a src directory.
 `,
 	Run: runRestore,
 }
 
+var (
+	restoreP     = runtime.NumCPU()
+	restoreN     bool
+	restoreForce bool
+	restoreStash bool
+
+	restoreInto   string
+	restoreGopath string
+)
+
+func init() {
+	cmdRestore.Flag.IntVar(&restoreP, "p", restoreP, "update at most n repos at a time")
+	cmdRestore.Flag.BoolVar(&restoreN, "n", false, "print the planned changes only")
+	cmdRestore.Flag.BoolVar(&restoreForce, "force", false, "update repos with local changes")
+	cmdRestore.Flag.BoolVar(&restoreStash, "stash", false, "stash uncommitted changes")
+	cmdRestore.Flag.StringVar(&restoreInto, "into", "", "download into GOPATH entry `dir`")
+	cmdRestore.Flag.StringVar(&restoreGopath, "gopath", "", "build a new GOPATH in `dir`")
+}
+
+// A stashVCS can set aside uncommitted changes in a working
+// copy. If it can't for a particular repo, it returns
+// errNoStash.
+type stashVCS interface {
+	canStash() bool
+	stash(dir string) error
+	unstash(dir string) error
+}
+
+// canStash reports whether v can stash uncommitted changes.
+func canStash(v VCS) bool {
+	s, ok := v.(stashVCS)
+	return ok && s.canStash()
+}
+
+// An unpushedVCS can tell whether the current branch of a
+// working copy has commits that are in no remote.
+type unpushedVCS interface {
+	unpushed(dir string) bool
+}
+
+// A branchVCS can tell which branch, if any, a working copy
+// is on, and put it back there at the checked-out revision,
+// so that rollback can restore the branch along with the
+// revision.
+type branchVCS interface {
+	branch(dir string) string
+	branchSync(dir, branch string) error
+}
+
+var errNoStash = errors.New("stash not supported")
+
+// A repoChange is the planned update of one repo in GOPATH.
+type repoChange struct {
+	root    string // import path of the repo root
+	dir     string
+	vcs     VCS
+	old     string // revision before restore; "" if not in GOPATH
+	branch  string // branch before restore, if any
+	new     string
+	touched bool // restore may have changed the working copy
+
+	dirty    bool // uncommitted changes
+	unpushed bool // commits in no remote
+	stash    bool // stash changes before updating
+	stashed  bool
+}
+
 func runRestore(cmd *Command, args []string) {
+	if restoreGopath != "" && (restoreInto != "" || restoreN) {
+		cmd.UsageExit()
+	}
 	g, err := ReadAndLoadDeps(findDepsJSON())
 	if err != nil {
 		log.Fatalln(err)
 	}
-	hadError := false
-	for _, dep := range g.Deps {
-		err := restore(dep)
-		if err != nil {
+	if restoreGopath != "" {
+		if err := restoreIsolated(g, findDeps(), restoreGopath); err != nil {
+			log.Fatalln("restore:", err)
+		}
+		return
+	}
+	plan, errs := planRestore(g.Deps, !restoreN)
+	if len(errs) == 0 && restoreN {
+		if jsonOutput {
+			emitPlan(plan)
+			return
+		}
+		printPlan(os.Stdout, plan)
+		return
+	}
+	if len(errs) == 0 {
+		errs = checkRestore(plan, restoreForce, restoreStash)
+	}
+	if len(errs) > 0 {
+		for _, err := range errs {
 			log.Println("restore:", err)
-			hadError = true
 		}
+		os.Exit(1)
 	}
-	if hadError {
+	if !applyRestore(plan) {
 		os.Exit(1)
 	}
 }
 
-// restore downloads the given dependency and checks out
-// the given revision.
-func restore(dep Dependency) error {
-	// make sure pkg exists somewhere in GOPATH
-	err := runIn(".", "go", "get", "-d", dep.ImportPath)
+// planRestore makes sure each dependency is somewhere in
+// GOPATH and returns the changes needed to check out the
+// listed revisions, one per repo.
+// In offline mode, the dependencies must already be in GOPATH.
+// If download is false, nothing is downloaded; dependencies
+// not in GOPATH get a change with a nil vcs.
+func planRestore(deps []Dependency, download bool) (plan []*repoChange, errs []error) {
+	var paths []string
+	for _, dep := range deps {
+		paths = append(paths, dep.ImportPath)
+	}
+	if download && !offline {
+		for _, err := range parallel(len(deps), restoreP, func(i int) error {
+			return deps[i].clone()
+		}) {
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if len(errs) > 0 {
+			return nil, errs
+		}
+		gopath, err := downloadGopath()
+		if err != nil {
+			return nil, []error{err}
+		}
+		if err := runInGopath(".", gopath, "go", append([]string{"get", "-d"}, paths...)...); err != nil {
+			return nil, []error{err}
+		}
+	}
+	ps, err := LoadPackages(paths...)
+	if err != nil {
+		return nil, []error{err}
+	}
+	pkgs := make(map[string]*Package)
+	for _, pkg := range ps {
+		pkgs[pkg.ImportPath] = pkg
+	}
+	byDir := make(map[string]*repoChange)
+	for _, dep := range deps {
+		pkg := pkgs[dep.ImportPath]
+		if (pkg == nil || pkg.Dir == "") && !download && !offline {
+			root := dep.ImportPath
+			if dep.repoRoot != nil {
+				root = dep.repoRoot.Root
+			}
+			if byDir[root] == nil {
+				byDir[root] = &repoChange{root: root, new: dep.Rev}
+				plan = append(plan, byDir[root])
+			}
+			continue
+		}
+		if pkg == nil || pkg.Dir == "" {
+			errs = append(errs, errors.New(dep.ImportPath+": not in GOPATH"))
+			continue
+		}
+		src := filepath.Join(pkg.Root, "src")
+		v, root, err := VCSFromDir(pkg.Dir, src)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", dep.ImportPath, err))
+			continue
+		}
+		dir := filepath.Join(src, filepath.FromSlash(root))
+		if c := byDir[dir]; c != nil {
+			if c.new != dep.Rev {
+				errs = append(errs, fmt.Errorf("%s: conflicting revisions %s and %s", root, c.new, dep.Rev))
+			}
+			continue
+		}
+		if offline && !v.exists(dir, dep.Rev) {
+			errs = append(errs, errors.New(dep.ImportPath+": revision "+dep.Rev+" not available locally"))
+			continue
+		}
+		old, err := v.identify(dir)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", dep.ImportPath, err))
+			continue
+		}
+		c := &repoChange{root: root, dir: dir, vcs: v, old: old, new: dep.Rev}
+		if b, ok := v.(branchVCS); ok {
+			c.branch = b.branch(dir)
+		}
+		logv("%s: %s at %s, want %s", root, dir, old, dep.Rev)
+		if old != dep.Rev {
+			c.dirty = v.isDirty(dir, old)
+			if u, ok := v.(unpushedVCS); ok {
+				c.unpushed = u.unpushed(dir)
+			}
+		}
+		byDir[dir] = c
+		plan = append(plan, c)
+	}
+	return plan, errs
+}
+
+// checkRestore refuses changes to repos with local work,
+// unless force is set. If stash is set, uncommitted changes
+// are to be stashed instead, where the VCS can do that.
+func checkRestore(plan []*repoChange, force, stash bool) (errs []error) {
+	for _, c := range plan {
+		c.stash = stash && c.dirty && canStash(c.vcs)
+		if force {
+			continue
+		}
+		if stash && c.dirty && !c.stash {
+			errs = append(errs, errors.New(c.root+": cannot stash "+c.vcs.name()+" changes (use -force)"))
+			continue
+		}
+		if c.dirty && !c.stash {
+			errs = append(errs, errors.New(c.root+": uncommitted changes (use -stash or -force)"))
+		}
+		if c.unpushed {
+			errs = append(errs, errors.New(c.root+": unpushed commits (use -force)"))
+		}
+	}
+	return errs
+}
+
+// printPlan writes the change planned for each repo to w.
+func printPlan(w io.Writer, plan []*repoChange) {
+	for _, c := range plan {
+		switch {
+		case c.vcs == nil:
+			fmt.Fprintf(w, "%s: download, check out %s\n", c.root, c.new)
+		case c.old == c.new:
+			fmt.Fprintf(w, "%s: %s (unchanged)\n", c.root, c.new)
+		default:
+			var note string
+			if c.dirty {
+				note += " (uncommitted changes)"
+			}
+			if c.unpushed {
+				note += " (unpushed commits)"
+			}
+			fmt.Fprintf(w, "%s: %s -> %s%s\n", c.root, c.old, c.new, note)
+		}
+	}
+}
+
+// emitPlan emits an Event for the change planned for each repo.
+func emitPlan(plan []*repoChange) {
+	for _, c := range plan {
+		e := Event{Action: "plan", Root: c.root, Dir: c.dir, OldRev: c.old, Rev: c.new}
+		if c.vcs == nil {
+			e.Notes = append(e.Notes, "download")
+		}
+		if c.dirty {
+			e.Notes = append(e.Notes, "uncommitted changes")
+		}
+		if c.unpushed {
+			e.Notes = append(e.Notes, "unpushed commits")
+		}
+		emit(e)
+	}
+}
+
+// applyRestore carries out plan, reporting progress as each
+// repo is done. If any change fails, it rolls back all the
+// repos it touched and returns false.
+func applyRestore(plan []*repoChange) bool {
+	var mu sync.Mutex
+	done := 0
+	errs := parallel(len(plan), restoreP, func(i int) error {
+		c := plan[i]
+		err := c.apply()
+		mu.Lock()
+		defer mu.Unlock()
+		done++
+		if err == nil {
+			info(Event{Action: "restore", Root: c.root, Dir: c.dir, OldRev: c.old, Rev: c.new},
+				"restore: [%d/%d] %s %s", done, len(plan), c.root, c.new)
+		}
+		return err
+	})
+	ok := true
+	for i, err := range errs {
+		if err != nil {
+			report(Event{Action: "fail", Root: plan[i].root, Dir: plan[i].dir, Rev: plan[i].new, Error: err.Error()},
+				"restore: %s: %v", plan[i].root, err)
+			ok = false
+		}
+	}
+	if ok {
+		return true
+	}
+	for _, c := range plan {
+		if !c.touched {
+			continue
+		}
+		if err := c.vcs.RevSync(c.dir, c.old); err != nil {
+			log.Printf("restore: rollback %s to %s: %v", c.root, c.old, err)
+			continue
+		}
+		if c.branch != "" {
+			// Put the branch back at c.old, in case the
+			// download moved it.
+			if err := c.vcs.(branchVCS).branchSync(c.dir, c.branch); err != nil {
+				log.Printf("restore: rollback %s to branch %s: %v", c.root, c.branch, err)
+			}
+		}
+		if c.stashed {
+			if err := c.vcs.(stashVCS).unstash(c.dir); err != nil {
+				log.Printf("restore: unstash %s: %v", c.root, err)
+			}
+		}
+		report(Event{Action: "rollback", Root: c.root, Dir: c.dir, Rev: c.old},
+			"restore: rolled back %s to %s", c.root, c.old)
+	}
+	return false
+}
+
+// apply checks out c.new, downloading it first if needed.
+// Changes are stashed before anything else is done.
+func (c *repoChange) apply() error {
+	if c.old == c.new {
+		return nil
+	}
+	if c.stash {
+		s, ok := c.vcs.(stashVCS)
+		if !ok {
+			return errNoStash
+		}
+		if err := s.stash(c.dir); err != nil {
+			return err
+		}
+		c.stashed = true
+		info(Event{Action: "stash", Root: c.root, Dir: c.dir}, "restore: stashed changes in %s", c.dir)
+	}
+	if !c.vcs.exists(c.dir, c.new) {
+		c.vcs.download(c.dir)
+	}
+	c.touched = true
+	return c.vcs.RevSync(c.dir, c.new)
+}
+
+// downloadGopath returns the entries of the outer GOPATH,
+// with the one new packages are downloaded into first.
+func downloadGopath() ([]string, error) {
+	gopath := filepath.SplitList(outerGopath())
+	if restoreInto == "" {
+		return gopath, nil
+	}
+	into, err := filepath.Abs(restoreInto)
+	if err != nil {
+		return nil, err
+	}
+	for i, dir := range gopath {
+		if abs, err := filepath.Abs(dir); err == nil && abs == into {
+			return append([]string{dir}, append(gopath[:i:i], gopath[i+1:]...)...), nil
+		}
+	}
+	return nil, errors.New(restoreInto + " is not in $GOPATH")
+}
+
+// restoreIsolated makes dir a GOPATH holding copies of the
+// project in projectDir and the sandbox checkouts of its
+// dependencies.
+func restoreIsolated(g *Deps, projectDir, dir string) error {
+	abs, err := filepath.Abs(dir)
 	if err != nil {
 		return err
 	}
-	ps, err := LoadPackages(dep.ImportPath)
+	sep := string(filepath.Separator)
+	if strings.HasPrefix(abs+sep, projectDir+sep) {
+		return errors.New(dir + " is inside the project")
+	}
+	src := filepath.Join(abs, "src")
+	if exists(src) {
+		return errors.New(src + " already exists")
+	}
+	gopaths, err := sandboxDeps(g.Deps)
 	if err != nil {
 		return err
 	}
-	pkg := ps[0]
-	if !dep.vcs.exists(pkg.Dir, dep.Rev) {
-		dep.vcs.vcs.Download(pkg.Dir)
+	copied := make(map[string]bool)
+	for i, d := range g.Deps {
+		root := filepath.FromSlash(d.repoRoot.Root)
+		if copied[root] {
+			continue
+		}
+		copied[root] = true
+		err := copyTree(filepath.Join(src, root), filepath.Join(gopaths[i], "src", root))
+		if err != nil {
+			return err
+		}
+	}
+	return copyTree(filepath.Join(src, filepath.FromSlash(g.ImportPath)), projectDir)
+}
+
+// clone clones d's repo into the download GOPATH entry, trying
+// the main remote and then each mirror. It does nothing if
+// the repo is already in GOPATH, or if its root was looked up
+// by go get and no rewrite rule or mirror applies; go get
+// downloads it in that case. A root from the Resolve table is
+// always cloned here, so go get never looks it up.
+func (d Dependency) clone() error {
+	remotes := d.remotes()
+	if d.outerRoot != "" || !d.static && len(remotes) == 1 && d.RemoteURL() == d.repoRoot.Repo {
+		return nil
+	}
+	gopath, err := downloadGopath()
+	if err != nil || len(gopath) == 0 {
+		return err
+	}
+	dir := filepath.Join(gopath[0], "src", filepath.FromSlash(d.repoRoot.Root))
+	if exists(dir) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0777); err != nil {
+		return err
+	}
+	for _, r := range remotes {
+		if r.url == "" {
+			continue
+		}
+		logv("%s: cloning %s", d.repoRoot.Root, r.url)
+		if err = d.vcs.clone(dir, r.url); err == nil {
+			return nil
+		}
+		os.RemoveAll(dir)
 	}
-	return dep.vcs.RevSync(pkg.Dir, dep.Rev)
+	return err
 }
 
 func findDepsJSON() (path string) {
 	dir := findDeps()
 	if dir == "" {
-		log.Fatalln("No Deps found (or in any parent directory)")
+		log.Fatalf("No %s found (or in any parent directory)", depsFile)
 	}
-	return filepath.Join(dir, "Deps")
+	return depsPath(dir)
 }
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestApplyRestoreRollback(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir, err := ioutil.TempDir("", "deppyrestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var plan []*repoChange
+	for _, name := range []string{"a", "b"} {
+		repo := filepath.Join(dir, name)
+		if err := writeFile(filepath.Join(repo, name+".go"), pkg(name)); err != nil {
+			t.Fatal(err)
+		}
+		run(t, repo, "git", "init", "-q")
+		run(t, repo, "git", "add", ".")
+		run(t, repo, "git", "commit", "-q", "-m", "one")
+		rev, err := vcsGit.identify(repo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		run(t, repo, "git", "commit", "-q", "--allow-empty", "-m", "two")
+		head, err := vcsGit.identify(repo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		branch := vcsGit.branch(repo)
+		if branch == "" {
+			t.Fatalf("%s: no branch", name)
+		}
+		plan = append(plan, &repoChange{root: name, dir: repo, vcs: vcsGit, old: head, new: rev, branch: branch})
+	}
+	plan[1].new = "0123456789012345678901234567890123456789"
+
+	// Repo c tracks an upstream that is ahead of it, and
+	// moves to the upstream's new commit, which must be
+	// downloaded without moving c's branch.
+	up := filepath.Join(dir, "up")
+	if err := writeFile(filepath.Join(up, "c.go"), pkg("c")); err != nil {
+		t.Fatal(err)
+	}
+	run(t, up, "git", "init", "-q")
+	run(t, up, "git", "add", ".")
+	run(t, up, "git", "commit", "-q", "-m", "one")
+	c := filepath.Join(dir, "c")
+	run(t, dir, "git", "clone", "-q", up, c)
+	run(t, up, "git", "commit", "-q", "--allow-empty", "-m", "two")
+	head, err := vcsGit.identify(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ahead, err := vcsGit.identify(up)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan = append(plan, &repoChange{root: "c", dir: c, vcs: vcsGit, old: head, new: ahead, branch: vcsGit.branch(c)})
+
+	if applyRestore(plan) {
+		t.Fatal("applyRestore succeeded, want failure")
+	}
+	for _, c := range plan {
+		g, err := vcsGit.identify(c.dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g != c.old {
+			t.Errorf("%s at %s, want rolled back to %s", c.root, g, c.old)
+		}
+		if g := vcsGit.branch(c.dir); g != c.branch {
+			t.Errorf("%s on branch %q, want rolled back to %q", c.root, g, c.branch)
+		}
+		out, err := exec.Command("git", "-C", c.dir, "rev-parse", "refs/heads/"+c.branch).Output()
+		if g := strings.TrimSpace(string(out)); err != nil || g != c.old {
+			t.Errorf("%s branch %s at %s, want %s", c.root, c.branch, g, c.old)
+		}
+	}
+	for _, c := range []*repoChange{plan[0], plan[2]} {
+		if !c.touched {
+			t.Errorf("%s not touched", c.root)
+		}
+	}
+
+	plan = plan[:1]
+	if !applyRestore(plan) {
+		t.Fatal("applyRestore failed")
+	}
+	if g, _ := vcsGit.identify(plan[0].dir); g != plan[0].new {
+		t.Errorf("%s at %s, want %s", plan[0].root, g, plan[0].new)
+	}
+}
+
+func TestCheckRestore(t *testing.T) {
+	var cases = []struct {
+		dirty, unpushed bool
+		force, stash    bool
+		wantErr         bool
+		wantStash       bool
+		vcs             VCS
+	}{
+		{false, false, false, false, false, false, vcsGit},
+		{true, false, false, false, true, false, vcsGit},
+		{true, false, false, true, false, true, vcsGit},
+		{true, false, true, false, false, false, vcsGit},
+		{false, true, false, false, true, false, vcsGit},
+		{false, true, false, true, true, false, vcsGit},
+		{false, true, true, false, false, false, vcsGit},
+		{true, true, true, true, false, true, vcsGit},
+		{true, false, false, true, true, false, vcsBzr},
+		{true, false, true, true, false, false, vcsBzr},
+		{false, false, false, true, false, false, vcsBzr},
+	}
+	for _, test := range cases {
+		c := &repoChange{root: "r", vcs: test.vcs, dirty: test.dirty, unpushed: test.unpushed}
+		errs := checkRestore([]*repoChange{c}, test.force, test.stash)
+		if g := len(errs) > 0; g != test.wantErr {
+			t.Errorf("%+v: errs = %v", test, errs)
+		}
+		if c.stash != test.wantStash {
+			t.Errorf("%+v: stash = %v want %v", test, c.stash, test.wantStash)
+		}
+	}
+}
+
+func TestRestoreStash(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir, err := ioutil.TempDir("", "deppyrestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := filepath.Join(dir, "a")
+	if err := writeFile(filepath.Join(repo, "a.go"), pkg("a")); err != nil {
+		t.Fatal(err)
+	}
+	run(t, repo, "git", "init", "-q")
+	run(t, repo, "git", "add", ".")
+	run(t, repo, "git", "commit", "-q", "-m", "one")
+	rev, err := vcsGit.identify(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run(t, repo, "git", "commit", "-q", "--allow-empty", "-m", "two")
+	head, err := vcsGit.identify(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !vcsGit.unpushed(repo) {
+		t.Error("unpushed = false, want true with no remotes")
+	}
+	if err := writeFile(filepath.Join(repo, "a.go"), pkg("a", "fmt")); err != nil {
+		t.Fatal(err)
+	}
+	if !vcsGit.isDirty(repo, head) {
+		t.Fatal("isDirty = false, want true")
+	}
+
+	c := &repoChange{root: "a", dir: repo, vcs: vcsGit, old: head, new: rev, stash: true}
+	if err := c.apply(); err != nil {
+		t.Fatal(err)
+	}
+	if g, _ := vcsGit.identify(repo); g != rev {
+		t.Errorf("at %s, want %s", g, rev)
+	}
+	if vcsGit.isDirty(repo, rev) {
+		t.Error("changes not stashed")
+	}
+
+	// Roll back by hand, as applyRestore does.
+	if err := vcsGit.RevSync(repo, head); err != nil {
+		t.Fatal(err)
+	}
+	if err := vcsGit.unstash(repo); err != nil {
+		t.Fatal(err)
+	}
+	if !vcsGit.isDirty(repo, head) {
+		t.Error("changes not restored")
+	}
+}
+
+func TestDownloadGopath(t *testing.T) {
+	defer os.Setenv("GOPATH", os.Getenv("GOPATH"))
+	defer func(s string) { restoreInto = s }(restoreInto)
+	var cases = []struct {
+		gopath  string
+		into    string
+		want    []string
+		wantErr bool
+	}{
+		{"/a:/b:/c", "", []string{"/a", "/b", "/c"}, false},
+		{"/a:/b:/c", "/b", []string{"/b", "/a", "/c"}, false},
+		{"/a:/b:/c", "/c/", []string{"/c", "/a", "/b"}, false},
+		{"/a:/b:/c", "/d", nil, true},
+	}
+	for _, test := range cases {
+		os.Setenv("GOPATH", test.gopath)
+		restoreInto = test.into
+		g, err := downloadGopath()
+		if (err != nil) != test.wantErr {
+			t.Errorf("downloadGopath() with %q err = %v", test.into, err)
+		}
+		if strings.Join(g, ":") != strings.Join(test.want, ":") {
+			t.Errorf("downloadGopath() with %q = %v want %v", test.into, g, test.want)
+		}
+	}
+}
+
+func TestCloneStatic(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir, err := ioutil.TempDir("", "deppyrestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("GOPATH", os.Getenv("GOPATH"))
+	defer func(s string) { restoreInto = s }(restoreInto)
+	restoreInto = ""
+
+	remote := filepath.Join(dir, "remote")
+	if err := writeFile(filepath.Join(remote, "a.go"), pkg("a")); err != nil {
+		t.Fatal(err)
+	}
+	run(t, remote, "git", "init", "-q")
+	run(t, remote, "git", "add", ".")
+	run(t, remote, "git", "commit", "-q", "-m", "a")
+
+	gopath := filepath.Join(dir, "gopath")
+	os.Setenv("GOPATH", gopath)
+	d := Dependency{ImportPath: "example.com/a/b"}
+	if err := d.resolve([]Resolution{{ImportPath: "example.com/a", VCS: "git", Repo: remote}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.clone(); err != nil {
+		t.Fatal(err)
+	}
+	if !exists(filepath.Join(gopath, "src", "example.com", "a", "a.go")) {
+		t.Error("repo from the Resolve table not cloned")
+	}
+}
+
+func TestRestoreIsolated(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir, err := ioutil.TempDir("", "deppyrestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(s string) { spool = s }(spool)
+	spool = filepath.Join(dir, "spool")
+
+	remote := filepath.Join(dir, "remote")
+	if err := writeFile(filepath.Join(remote, "r.go"), pkg("r")); err != nil {
+		t.Fatal(err)
+	}
+	run(t, remote, "git", "init", "-q")
+	run(t, remote, "git", "add", ".")
+	run(t, remote, "git", "commit", "-q", "-m", "one")
+	rev, err := vcsGit.identify(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(filepath.Join(remote, "r.go"), pkg("r", "fmt")); err != nil {
+		t.Fatal(err)
+	}
+	run(t, remote, "git", "commit", "-q", "-a", "-m", "two")
+
+	project := filepath.Join(dir, "project")
+	for name, body := range map[string]string{
+		"p.go":     pkg("p", "example.com/r"),
+		"Deps":     "{}\n",
+		".git/x":   "ignored\n",
+		"_work/x":  "ignored\n",
+		"sub/s.go": pkg("sub"),
+	} {
+		if err := writeFile(filepath.Join(project, filepath.FromSlash(name)), body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g := &Deps{
+		ImportPath: "example.com/p",
+		Deps: []Dependency{{
+			ImportPath: "example.com/r",
+			Rev:        rev,
+			repoRoot:   &vcs.RepoRoot{Root: "example.com/r", Repo: remote},
+			vcs:        vcsGit,
+		}},
+	}
+	gopath := filepath.Join(dir, "gopath")
+	if err := restoreIsolated(g, project, gopath); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(gopath, "src", "example.com")
+	for name, want := range map[string]bool{
+		"p/p.go":     true,
+		"p/Deps":     true,
+		"p/sub/s.go": true,
+		"p/.git/x":   false,
+		"p/_work/x":  false,
+		"r/r.go":     true,
+	} {
+		if g := exists(filepath.Join(src, filepath.FromSlash(name))); g != want {
+			t.Errorf("%s present = %v want %v", name, g, want)
+		}
+	}
+	body, err := ioutil.ReadFile(filepath.Join(src, "r", "r.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := string(body); g != pkg("r") {
+		t.Errorf("r.go = %q want %q", g, pkg("r"))
+	}
+	if err := restoreIsolated(g, project, gopath); err == nil {
+		t.Error("second restoreIsolated succeeded, want error")
+	}
+}
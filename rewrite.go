@@ -24,7 +24,7 @@ func rewrite(pkgs []*Package, qual string, paths []string) error {
 			return err
 		}
 	}
-	return rewriteTree("Deps", qual, paths)
+	return rewriteTree(depsFile, qual, paths)
 }
 
 // pkgFiles returns the full filesystem path to all go files in pkgs.
@@ -14,12 +14,14 @@ import (
 )
 
 var cmdSave = &Command{
-	Usage: "save [-r] [-copy=false] [packages]",
+	Usage: "save [-r] [-copy=false] [-merge-nested] [packages]",
 	Short: "list and copy dependencies into Deps",
 	Long: `
 Save writes a list of the dependencies of the named packages along
 with the exact source control revision of each dependency to a file
-named "Deps".
+named "Deps", or the file named by the global flag -deps. Unlike
+other commands, save does not look for the file in parent
+directories: a relative name is written in the current directory.
 
 The dependency list is a JSON document with the following structure:
 
@@ -27,6 +29,11 @@ The dependency list is a JSON document with the following structure:
 		ImportPath string
 		GoVersion  string   // Abridged output of 'go version'.
 		Packages   []string // Arguments to deppy save, if any.
+		Resolve    []struct {
+			ImportPath string // Import path of a repo root.
+			VCS        string // VCS command, e.g. "git".
+			Repo       string // Repo URL.
+		}
 		Deps       []struct {
 			ImportPath string
 			Comment    string // Tag or description of commit.
@@ -36,17 +43,35 @@ The dependency list is a JSON document with the following structure:
 
 Any dependencies already present in the list will be left unchanged.
 
+Save warns about dependencies whose own Deps or Godeps file pins
+a repo at a different revision; see 'deppy help conflicts'.
+
+The -merge-nested flag instead resolves such conflicts. For each
+repo, save records the newest of the revisions required by the
+named packages and by the Deps files of the dependencies, repeating
+until they agree. Revisions are compared in the spool repos used by
+'deppy go'. Save fails if two required revisions are on divergent
+branches, since neither contains the other.
+
+The Resolve list is maintained by hand and kept by save. Its
+entries tell deppy where to find repos, such as those with
+vanity import paths or on private hosts, without looking them
+up over the network. They can also be given in a .deppy config
+file.
+
 For more about specifying packages, see 'go help packages'.
 `,
 	Run: runSave,
 }
 
 var (
-	saveCopy = true
+	saveCopy        = true
+	saveMergeNested bool
 )
 
 func init() {
 	cmdSave.Flag.BoolVar(&saveCopy, "copy", false, "copy source code")
+	cmdSave.Flag.BoolVar(&saveMergeNested, "merge-nested", false, "merge revisions from dependencies' Deps files")
 }
 
 func runSave(cmd *Command, args []string) {
@@ -58,7 +83,10 @@ func runSave(cmd *Command, args []string) {
 
 func save(pkgs []string) error {
 	if saveCopy {
-		log.Println(strings.TrimSpace(copyWarning))
+		warn(strings.TrimSpace(copyWarning))
+	}
+	if fi, err := os.Stat(depsFile); err == nil && fi.IsDir() && depsFile != "Deps" {
+		return errors.New(depsFile + " is a directory")
 	}
 	dot, err := LoadPackages(".")
 	if err != nil {
@@ -68,11 +96,16 @@ func save(pkgs []string) error {
 	if err != nil {
 		return err
 	}
-	manifest := "Deps"
-	var gold Deps
+	manifest := depsFile
+	var gold, gprev Deps
+	err = ReadDeps(manifest, &gprev)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	gnew := &Deps{
 		ImportPath: dot[0].ImportPath,
 		GoVersion:  ver,
+		Resolve:    gprev.Resolve, // maintained by hand
 	}
 	if len(pkgs) > 0 {
 		gnew.Packages = pkgs
@@ -94,13 +127,30 @@ func save(pkgs []string) error {
 	if gnew.Deps == nil {
 		gnew.Deps = make([]Dependency, 0) // produce json [], not null
 	}
+	for _, d := range gnew.Deps {
+		logv("%s: %s", d.ImportPath, d.Rev)
+	}
 	err = carryVersions(&gold, gnew)
 	if err != nil {
 		return err
 	}
-	err = os.RemoveAll("Deps")
-	if err != nil {
-		log.Println(err)
+	if saveMergeNested {
+		prepareSandbox(gnew.Deps)
+		if err := mergeNested(gnew.Deps); err != nil {
+			return err
+		}
+	} else {
+		warnConflicts(gnew.Deps)
+	}
+	if manifest == "Deps" {
+		// Clear out a Deps directory left by old versions.
+		err = os.RemoveAll(manifest)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(manifest), 0777); err != nil {
+		return err
 	}
 	f, err := os.Create(manifest)
 	if err != nil {
@@ -114,10 +164,35 @@ func save(pkgs []string) error {
 	if err != nil {
 		return err
 	}
+	if jsonOutput {
+		for _, d := range gnew.Deps {
+			emit(Event{Action: "save", ImportPath: d.ImportPath, Rev: d.Rev, Comment: d.Comment})
+		}
+	}
 	var rewritePaths []string
 	return rewrite(a, dot[0].ImportPath, rewritePaths)
 }
 
+// warnConflicts reports dependencies of deps, checked out
+// in GOPATH, whose manifests disagree with deps.
+func warnConflicts(deps []Dependency) {
+	pins := make(map[string]string)
+	dirs := make(map[string]string)
+	for _, d := range deps {
+		pins[d.root] = d.Rev
+		dirs[d.root] = filepath.Join(d.ws, "src", filepath.FromSlash(d.root))
+	}
+	ms, err := readManifests(dirs)
+	if err != nil {
+		warn(err)
+		return
+	}
+	if c := findConflicts(pins, ms); len(c) > 0 && !quiet() {
+		warn("dependencies pin other revisions:")
+		printConflicts(os.Stderr, c)
+	}
+}
+
 type revError struct {
 	ImportPath string
 	HaveRev    string
@@ -189,8 +264,8 @@ Diff:
 // with the `deppy go` sandbox code.
 func badSandboxVCS(deps []Dependency) (a []string) {
 	for _, d := range deps {
-		if d.vcs.CreateCmd == "" {
-			a = append(a, d.vcs.vcs.Name)
+		if !d.vcs.canSandbox() {
+			a = append(a, d.vcs.name())
 		}
 	}
 	sort.Strings(a)
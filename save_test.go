@@ -262,3 +262,30 @@ func run(t *testing.T, dir, name string, args ...string) string {
 	}
 	return string(out)
 }
+
+func TestSaveDepsDir(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	defer func() { depsFile = "Deps" }()
+	dir, err := ioutil.TempDir("", "deppysave")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := writeFile(filepath.Join(dir, "ci", "keep"), "x\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	depsFile = "ci"
+	if err := save(nil); err == nil {
+		t.Error("save with -deps naming a directory succeeded")
+	}
+	if !exists(filepath.Join(dir, "ci", "keep")) {
+		t.Error("save removed the -deps directory")
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A sparseVCS can check out only some directories of a
+// revision. If it can't for a particular repo, it returns
+// errNoSparse, and the whole revision is checked out.
+type sparseVCS interface {
+	// checkoutDirs writes the files directly in each of dirs,
+	// plus their testdata trees, from rev in sandbox repo repo
+	// into dir. The dirs are slash-separated and relative to
+	// the repo root, which is ".".
+	checkoutDirs(dir, rev, repo string, dirs []string) error
+}
+
+var errNoSparse = errors.New("sparse checkout not supported")
+
+// sparseMarker returns the path of the file listing the
+// directories present in the sparse checkout of d in gopath.
+// If there is no such file, the checkout is complete.
+func (d Dependency) sparseMarker(gopath string) string {
+	return filepath.Join(gopath, "sparse")
+}
+
+// checkedOut reports whether the files of d needed in the
+// sandbox are present in gopath.
+func (d Dependency) checkedOut(gopath string) bool {
+	if !exists(filepath.Join(gopath, "src", d.repoRoot.Root)) {
+		return false
+	}
+	have, err := readLines(d.sparseMarker(gopath))
+	if os.IsNotExist(err) {
+		return true
+	}
+	if err != nil || d.sparseDirs == nil {
+		return false
+	}
+	return len(subStrings(d.sparseDirs, have)) == 0
+}
+
+// checkoutSparse adds the directories in d.sparseDirs, and
+// those they import from the same repo, to the sparse
+// checkout of d in gopath.
+func (d Dependency) checkoutSparse(v sparseVCS, gopath string) error {
+	root := filepath.Join(gopath, "src", filepath.FromSlash(d.repoRoot.Root))
+	marker := d.sparseMarker(gopath)
+	have, err := readLines(marker)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// Write the marker first, so that an interrupted
+	// checkout is known to be incomplete.
+	if err := writeLines(marker, have); err != nil {
+		return err
+	}
+	todo := subStrings(d.sparseDirs, have)
+	for len(todo) > 0 {
+		err := v.checkoutDirs(root, d.Rev, d.RepoPath(), todo)
+		if err != nil {
+			return err
+		}
+		have = append(have, todo...)
+		var next []string
+		for _, dir := range todo {
+			for _, path := range goImports(filepath.Join(root, filepath.FromSlash(dir))) {
+				for _, rel := range importDirs(d.repoRoot.Root, dir, path) {
+					if !containsString(have, rel) && !containsString(next, rel) {
+						next = append(next, rel)
+					}
+				}
+			}
+		}
+		todo = next
+	}
+	sort.Strings(have)
+	return writeLines(marker, have)
+}
+
+// repoDirs returns the directories, relative to root, of
+// the packages in deps that are in the repo at root.
+func repoDirs(deps []Dependency, root string) (a []string) {
+	for _, d := range deps {
+		if rel, ok := repoRel(root, d.ImportPath); ok && !containsString(a, rel) {
+			a = append(a, rel)
+		}
+	}
+	return a
+}
+
+// repoRel returns importPath relative to the repo root
+// import path root, and whether it is in that repo.
+func repoRel(root, importPath string) (string, bool) {
+	switch {
+	case importPath == root:
+		return ".", true
+	case strings.HasPrefix(importPath, root+"/"):
+		return importPath[len(root)+1:], true
+	}
+	return "", false
+}
+
+// importDirs returns the directories, relative to the repo
+// root import path root, that may hold the package imported as
+// importPath from dir, itself relative to root: the vendor
+// directories of dir and its parents, innermost first, then the
+// directory of importPath if it is in the repo. Which one the go
+// tool uses can't be known until they are checked out, so the
+// caller takes them all.
+func importDirs(root, dir, importPath string) (a []string) {
+	elem := importPath
+	if i := strings.Index(importPath, "/"); i >= 0 {
+		elem = importPath[:i]
+	}
+	if !strings.Contains(elem, ".") {
+		return nil // standard library
+	}
+	for p := dir; ; p = path.Dir(p) {
+		if p == "." {
+			a = append(a, "vendor/"+importPath)
+			break
+		}
+		a = append(a, p+"/vendor/"+importPath)
+	}
+	if rel, ok := repoRel(root, importPath); ok {
+		a = append(a, rel)
+	}
+	return a
+}
+
+// goImports returns the import paths used by the non-test
+// go files in dir.
+func goImports(dir string) (a []string) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	fset := token.NewFileSet()
+	for _, fi := range fis {
+		name := fi.Name()
+		if c := name[0]; c == '.' || c == '_' {
+			continue
+		}
+		if fi.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ImportsOnly)
+		if err != nil {
+			continue
+		}
+		for _, s := range f.Imports {
+			if path, err := strconv.Unquote(s.Path.Value); err == nil {
+				a = append(a, path)
+			}
+		}
+	}
+	return a
+}
+
+// subStrings returns the elements of a not in b.
+func subStrings(a, b []string) (diff []string) {
+	for _, s := range a {
+		if !containsString(b, s) {
+			diff = append(diff, s)
+		}
+	}
+	return diff
+}
+
+func containsString(a []string, s string) bool {
+	for _, t := range a {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
+func readLines(name string) ([]string, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(b)), nil
+}
+
+func writeLines(name string, a []string) error {
+	var body string
+	for _, s := range a {
+		body += s + "\n"
+	}
+	return writeFile(name, body)
+}
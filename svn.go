@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// Subversion has no local repository to fetch into, so its
+// sandbox repo is a directory holding two files. File
+// svnRemotes lists the URL of each remote, and file svnRevs
+// lists, for each fetched revision, a remote URL known to
+// have it. Checkout exports the revision from that URL.
+// The fast remote is a working copy in GOPATH; only the
+// revision it is at can be fetched from it, but that needs
+// no network access.
+//
+// A Subversion revision is identified by the repository
+// UUID and the revision number, as in "UUID@1234".
+const (
+	svnRemotes = "svn-remotes"
+	svnRevs    = "svn-revs"
+)
+
+var vcsSvn = &cmdVCS{
+	vcs: vcs.ByCmd("svn"),
+
+	IdentifyFunc: svnIdentify,
+	DescribeFunc: svnDescribe,
+	DiffCmd:      "status -q",
+	RemoteCmd:    "info --show-item url",
+
+	CreateFunc:   svnCreate,
+	LinkFunc:     svnLink,
+	ExistsFunc:   svnExists,
+	FetchFunc:    svnFetch,
+	CheckoutFunc: svnCheckout,
+
+	RevSyncFunc: svnRevSync,
+}
+
+// svnRev splits rev into the repository UUID
+// and the revision number.
+func svnRev(rev string) (uuid, num string) {
+	i := strings.LastIndex(rev, "@")
+	if i < 0 {
+		return "", rev
+	}
+	return rev[:i], rev[i+1:]
+}
+
+func svnIdentify(v *cmdVCS, dir string) (string, error) {
+	uuid, err := v.runOutput(dir, "info --show-item repos-uuid")
+	if err != nil {
+		return "", err
+	}
+	num, err := v.runOutput(dir, "info --show-item revision")
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSpace(uuid)) + "@" + string(bytes.TrimSpace(num)), nil
+}
+
+func svnDescribe(v *cmdVCS, dir, rev string) string {
+	_, num := svnRev(rev)
+	return "r" + num
+}
+
+func svnCreate(v *cmdVCS, dir string) error {
+	return ioutil.WriteFile(filepath.Join(dir, svnRemotes), nil, 0666)
+}
+
+func svnLink(dir, remote, url string) error {
+	return setPair(filepath.Join(dir, svnRemotes), remote, url)
+}
+
+func svnExists(v *cmdVCS, dir, rev string) bool {
+	url, _ := lookupPair(filepath.Join(dir, svnRevs), rev)
+	return url != ""
+}
+
+// svnFetch checks that the remote has rev and records
+// its URL for use by svnCheckout.
+func svnFetch(v *cmdVCS, dir, remote, rev string) error {
+	url, err := lookupPair(filepath.Join(dir, svnRemotes), remote)
+	if err != nil {
+		return err
+	}
+	if url == "" {
+		return fmt.Errorf("no remote %s", remote)
+	}
+	if exists(url) {
+		have, err := svnIdentify(v, url)
+		if err != nil {
+			return err
+		}
+		if have != rev {
+			return fmt.Errorf("working copy %s is at %s", url, have)
+		}
+		return setPair(filepath.Join(dir, svnRevs), rev, url)
+	}
+	if offline {
+		return fmt.Errorf("%s not available offline", rev)
+	}
+	uuid, num := svnRev(rev)
+	out, err := v.runOutput(dir, "info --show-item repos-uuid -r {num} {url}", "num", num, "url", url)
+	if err != nil {
+		return err
+	}
+	if string(bytes.TrimSpace(out)) != uuid {
+		return fmt.Errorf("%s is not repository %s", url, uuid)
+	}
+	return setPair(filepath.Join(dir, svnRevs), rev, url)
+}
+
+func svnCheckout(v *cmdVCS, dir, rev, repo string) error {
+	url, err := lookupPair(filepath.Join(repo, svnRevs), rev)
+	if err != nil {
+		return err
+	}
+	_, num := svnRev(rev)
+	if exists(url) {
+		num = "BASE" // working copy recorded by svnFetch
+	} else if offline {
+		return fmt.Errorf("%s not available offline", rev)
+	}
+	return v.run(dir, "export -q --force -r {num} {url} .", "num", num, "url", url)
+}
+
+func svnRevSync(v *cmdVCS, dir, rev string) error {
+	_, num := svnRev(rev)
+	return v.run(dir, "update -q -r {num}", "num", num)
+}
+
+// lookupPair returns the value for key in the file
+// of space-separated key value pairs, one per line,
+// or "" if key is not present.
+func lookupPair(name, key string) (string, error) {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		k, v := splitPair(sc.Text())
+		if k == key {
+			return v, nil
+		}
+	}
+	return "", sc.Err()
+}
+
+// setPair sets the value for key in the file of pairs read
+// by lookupPair, creating the file if necessary.
+func setPair(name, key, value string) error {
+	b, err := ioutil.ReadFile(name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, line := range strings.Split(string(b), "\n") {
+		if k, _ := splitPair(line); k != "" && k != key {
+			fmt.Fprintln(&buf, line)
+		}
+	}
+	fmt.Fprintln(&buf, key, value)
+	return ioutil.WriteFile(name, buf.Bytes(), 0666)
+}
+
+func splitPair(line string) (key, value string) {
+	f := strings.SplitN(strings.TrimSpace(line), " ", 2)
+	if len(f) != 2 {
+		return f[0], ""
+	}
+	return f[0], f[1]
+}
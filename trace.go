@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// These are set by the global flags -v, -x, and -q.
+var (
+	verboseOutput bool // report progress and show failing commands' output
+	traceCommands bool // print each command run
+	quietOutput   bool // print only errors
+)
+
+// logv logs a progress message with flag -v.
+func logv(format string, args ...interface{}) {
+	if verboseOutput && !jsonOutput {
+		log.Printf(format, args...)
+	}
+}
+
+// quiet reports whether to drop informational output. Flag -q
+// never suppresses JSON: programs want every Event.
+func quiet() bool {
+	return quietOutput && !jsonOutput
+}
+
+// info is like report, but prints nothing with flag -q.
+func info(e Event, format string, args ...interface{}) {
+	if !quiet() {
+		report(e, format, args...)
+	}
+}
+
+// warn logs a warning, unless flag -q is set.
+func warn(args ...interface{}) {
+	if !quiet() {
+		log.Println(append([]interface{}{"warning:"}, args...)...)
+	}
+}
+
+// runCmd is like c.Run, but traces c with flag -x.
+func runCmd(c *exec.Cmd) error {
+	start := time.Now()
+	err := c.Run()
+	traceCmd(c, start, err)
+	return err
+}
+
+// outputCmd is like c.Output, but traces c with flag -x.
+func outputCmd(c *exec.Cmd) ([]byte, error) {
+	start := time.Now()
+	out, err := c.Output()
+	traceCmd(c, start, err)
+	return out, err
+}
+
+var (
+	traceMu  sync.Mutex
+	traceOut io.Writer = os.Stderr
+)
+
+// traceCmd prints c, started at start, with its directory,
+// GOPATH, and running time, as a single line on stderr, so
+// lines from commands run in parallel don't mix.
+func traceCmd(c *exec.Cmd, start time.Time, err error) {
+	if !traceCommands {
+		return
+	}
+	var buf bytes.Buffer
+	if c.Dir != "" {
+		fmt.Fprintf(&buf, "cd %s; ", c.Dir)
+	}
+	for _, s := range c.Env {
+		if strings.HasPrefix(s, "GOPATH=") {
+			fmt.Fprintf(&buf, "%s ", s)
+		}
+	}
+	fmt.Fprintf(&buf, "%s # %.3fs", strings.Join(c.Args, " "), time.Since(start).Seconds())
+	if err != nil {
+		fmt.Fprintf(&buf, " (%v)", err)
+	}
+	buf.WriteByte('\n')
+	traceMu.Lock()
+	defer traceMu.Unlock()
+	traceOut.Write(buf.Bytes())
+}
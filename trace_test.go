@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"testing"
+)
+
+func TestTraceCmd(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not installed")
+	}
+	var buf bytes.Buffer
+	traceOut = &buf
+	defer func() { traceOut = os.Stderr }()
+	traceCommands = true
+	defer func() { traceCommands = false }()
+
+	c := exec.Command("sh", "-c", "exit 3")
+	c.Dir = os.TempDir()
+	c.Env = []string{"HOME=/", "GOPATH=/sandbox"}
+	runCmd(c)
+	c = exec.Command("sh", "-c", "echo hi")
+	if out, err := outputCmd(c); err != nil || string(out) != "hi\n" {
+		t.Errorf("outputCmd = %q, %v", out, err)
+	}
+
+	re := regexp.MustCompile(`^cd ` + regexp.QuoteMeta(os.TempDir()) + `; GOPATH=/sandbox sh -c exit 3 # \d+\.\d{3}s \(exit status 3\)
+sh -c echo hi # \d+\.\d{3}s
+$`)
+	if got := buf.String(); !re.MatchString(got) {
+		t.Errorf("trace output:\n%s", got)
+	}
+}
+
+func TestQuiet(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer log.SetOutput(os.Stderr)
+	defer log.SetFlags(log.LstdFlags)
+	defer func() { quietOutput, verboseOutput = false, false }()
+
+	var cases = []struct {
+		quiet, verbose bool
+		want           string
+	}{
+		{false, false, "info\nwarning: warn\n"},
+		{false, true, "verbose\ninfo\nwarning: warn\n"},
+		{true, false, ""},
+	}
+	for _, test := range cases {
+		buf.Reset()
+		quietOutput, verboseOutput = test.quiet, test.verbose
+		logv("verbose")
+		info(Event{}, "info")
+		warn("warn")
+		if got := buf.String(); got != test.want {
+			t.Errorf("quiet=%v verbose=%v: got %q, want %q", test.quiet, test.verbose, got, test.want)
+		}
+	}
+
+	// -q never suppresses JSON.
+	var events bytes.Buffer
+	eventOut = &events
+	defer func() { eventOut = os.Stdout }()
+	jsonOutput = true
+	defer func() { jsonOutput = false }()
+	buf.Reset()
+	quietOutput, verboseOutput = true, false
+	info(Event{Action: "restore"}, "info")
+	warn("warn")
+	if got, want := events.String()+buf.String(), "{\"Action\":\"restore\"}\nwarning: warn\n"; got != want {
+		t.Errorf("quiet json: got %q, want %q", got, want)
+	}
+}
@@ -3,6 +3,11 @@ package main
 import (
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/kr/fs"
 )
 
 // Returns true if path definitely exists; false if path doesn't
@@ -21,5 +26,50 @@ func runIn(dir, name string, args ...string) error {
 	c.Dir = dir
 	c.Stdout = os.Stdout
 	c.Stderr = os.Stderr
-	return c.Run()
+	return runCmd(c)
+}
+
+// parallel calls f(0) through f(n-1), at most p at a time,
+// and returns their results in the same order.
+func parallel(n, p int, f func(i int) error) []error {
+	if p < 1 {
+		p = 1
+	}
+	errs := make([]error, n)
+	sem := make(chan bool, p)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- true
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = f(i)
+			<-sem
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+// runInGopath is like runIn, but with GOPATH set to
+// the list of directories gopath.
+func runInGopath(dir string, gopath []string, name string, args ...string) error {
+	c := exec.Command(name, args...)
+	c.Dir = dir
+	c.Env = append(envNoGopath(), "GOPATH="+strings.Join(gopath, string(filepath.ListSeparator)))
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	return runCmd(c)
+}
+
+// copyTree copies the files of the package tree src into dst,
+// skipping directories the go tool ignores.
+func copyTree(dst, src string) error {
+	w := fs.Walk(src)
+	for w.Step() {
+		if err := copyPkgFile(dst, src, w); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -2,23 +2,55 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"golang.org/x/tools/go/vcs"
 )
 
-// VCS is a version control abstraction
-type VCS struct {
+// VCS is a version control abstraction. Built-in drivers
+// for bzr, git, hg, and svn are described by command line
+// templates in cmdVCS. Other systems are supported by
+// external driver programs; see driver.go.
+type VCS interface {
+	// name returns the name of the VCS command, e.g. "git".
+	name() string
+
+	// run in outer GOPATH
+	identify(dir string) (string, error)
+	describe(dir, rev string) string
+	isDirty(dir, rev string) bool
+	remoteURL(dir string) string
+
+	// run in sandbox repos
+	canSandbox() bool
+	create(dir string) error
+	link(dir, remote, url string) error
+	exists(dir, rev string) bool
+	fetch(dir, remote, rev string) error
+	checkout(dir, rev, repo string) error
+
+	// run in outer GOPATH by restore
+	clone(dir, url string) error
+	download(dir string) error
+	RevSync(dir, rev string) error
+}
+
+// cmdVCS is a VCS driver that runs the VCS's own command.
+type cmdVCS struct {
 	vcs *vcs.Cmd
 
 	// run in outer GOPATH
 	IdentifyCmd string
 	DescribeCmd string
 	DiffCmd     string
+	RemoteCmd   string
 
 	// run in sandbox repos
 	CreateCmd   string
@@ -26,58 +58,150 @@ type VCS struct {
 	ExistsCmd   string
 	FetchCmd    string
 	CheckoutCmd string
+	ResolveCmd  string // prints the ID of revision {ver}
 
 	// If nil, LinkCmd is used.
+	// Linking an existing remote must replace its URL.
 	LinkFunc func(dir, remote, url string) error
+
+	// If non-nil, these are used instead of the
+	// corresponding commands.
+	IdentifyFunc func(v *cmdVCS, dir string) (string, error)
+	DescribeFunc func(v *cmdVCS, dir, rev string) string
+	CreateFunc   func(v *cmdVCS, dir string) error
+	ExistsFunc   func(v *cmdVCS, dir, rev string) bool
+	FetchFunc    func(v *cmdVCS, dir, remote, rev string) error
+	CheckoutFunc func(v *cmdVCS, dir, rev, repo string) error
+
+	// If nil, sparse checkouts are not supported.
+	CheckoutDirsFunc func(v *cmdVCS, dir, rev, repo string, dirs []string) error
+
+	// run in outer GOPATH by restore
+	// DownloadCmd fetches new revisions without changing the
+	// working copy or its branch. If empty, the go tool's
+	// download command is used, which may do both.
+	DownloadCmd string
+
+	// run in outer GOPATH by restore
+	// If empty, the go tool's tag sync command is used.
+	RevSyncCmd  string
+	RevSyncFunc func(v *cmdVCS, dir, rev string) error
+
+	// run in outer GOPATH by restore
+	// UnpushedCmd prints something if the working copy
+	// has commits that are in no remote. If any of these
+	// is empty, the operation is not supported.
+	UnpushedCmd string
+	StashCmd    string
+	UnstashCmd  string
+
+	// run in outer GOPATH by restore
+	// BranchCmd prints the branch the working copy is on,
+	// if any, and BranchSyncCmd puts it back on {branch},
+	// resetting the branch to the checked-out revision.
+	// If either is empty, restore leaves repos it rolls
+	// back at a bare revision.
+	BranchCmd     string
+	BranchSyncCmd string
+
+	// run in sandbox repos by save -merge-nested
+	// MergeBaseCmd prints the common ancestor of revisions
+	// a and b. If both are empty, ancestry checks are not
+	// supported.
+	MergeBaseCmd   string
+	IsAncestorFunc func(v *cmdVCS, dir, a, b string) (bool, error)
 }
 
-var vcsBzr = &VCS{
+var vcsBzr = &cmdVCS{
 	vcs: vcs.ByCmd("bzr"),
 
-	IdentifyCmd: "version-info --custom --template {revision_id}",
-	DescribeCmd: "revno", // TODO(kr): find tag names if possible
-	DiffCmd:     "diff -r {rev}",
+	IdentifyCmd:  "version-info --custom --template {revision_id}",
+	DescribeFunc: bzrDescribe,
+	DiffCmd:      "diff -r {rev}",
+	RemoteCmd:    "config parent_location",
+
+	CreateCmd:   "init --no-tree",
+	LinkCmd:     "config --scope branch deppy_remote_{remote}={url}",
+	ExistsCmd:   "log -l 1 -r revid:{rev}",
+	FetchFunc:   bzrFetch,
+	CheckoutCmd: "export --format dir -r revid:{rev} . {repo}",
+
+	RevSyncCmd: "update -r revid:{rev}",
 }
 
-var vcsGit = &VCS{
+var vcsGit = &cmdVCS{
 	vcs: vcs.ByCmd("git"),
 
 	IdentifyCmd: "rev-parse HEAD",
-	DescribeCmd: "describe --tags",
+	DescribeCmd: "describe --tags {rev}",
 	DiffCmd:     "diff {rev}",
-
-	CreateCmd:   "init --bare",
-	LinkCmd:     "remote add {remote} {url}",
-	ExistsCmd:   "cat-file -e {rev}",
-	FetchCmd:    "fetch --quiet {remote}",
-	CheckoutCmd: "--git-dir {repo} --work-tree . checkout -q --force {rev}",
+	RemoteCmd:   "config remote.origin.url",
+
+	CreateCmd:    "init --bare",
+	LinkCmd:      "config remote.{remote}.url {url}",
+	ExistsCmd:    "cat-file -e {rev}",
+	FetchCmd:     "fetch --quiet --tags {remote} +refs/heads/*:refs/remotes/{remote}/*",
+	FetchFunc:    gitFetch,
+	CheckoutCmd:  "--git-dir {repo} --work-tree . checkout -q --force {rev}",
+	CheckoutFunc: gitCheckout,
+	ResolveCmd:   "rev-parse --verify -q {ver}^{commit}",
+
+	CheckoutDirsFunc: gitCheckoutDirs,
+
+	DownloadCmd: "fetch --quiet",
+	RevSyncFunc: gitRevSync,
+	UnpushedCmd: "rev-list -n 1 HEAD --not --remotes",
+	StashCmd:    "stash save -q deppy-restore",
+	UnstashCmd:  "stash pop -q",
+
+	BranchCmd:     "symbolic-ref -q --short HEAD",
+	BranchSyncCmd: "checkout -q -B {branch}",
+
+	MergeBaseCmd:   "merge-base {a} {b}",
+	IsAncestorFunc: gitIsAncestor,
 }
 
-var vcsHg = &VCS{
+var vcsHg = &cmdVCS{
 	vcs: vcs.ByCmd("hg"),
 
 	IdentifyCmd: "identify --id --debug",
-	DescribeCmd: "log -r . --template {latesttag}-{latesttagdistance}",
+	DescribeCmd: "log -r {rev} --template {latesttag}-{latesttagdistance}",
 	DiffCmd:     "diff -r {rev}",
+	RemoteCmd:   "paths default",
 
 	CreateCmd:   "init",
 	LinkFunc:    hgLink,
 	ExistsCmd:   "cat -r {rev} .",
 	FetchCmd:    "pull {remote}",
-	CheckoutCmd: "clone -u {rev} {repo} .",
+	CheckoutCmd: "archive --config ui.archivemeta=false -R {repo} -r {rev} -t files {dir}",
+	ResolveCmd:  "log -r {ver} --template {node}",
+
+	DownloadCmd: "pull -q",
+	UnpushedCmd: "log -q -r draft()&::.",
+	StashCmd:    "--config extensions.shelve= shelve -q",
+	UnstashCmd:  "--config extensions.shelve= unshelve -q",
+
+	BranchCmd:     "log -r . --template {activebookmark}",
+	BranchSyncCmd: "bookmark -q -f {branch}",
+
+	MergeBaseCmd: "log -r ancestor({a},{b}) --template {node}",
 }
 
-var cmd = map[*vcs.Cmd]*VCS{
+var cmd = map[*vcs.Cmd]*cmdVCS{
 	vcsBzr.vcs: vcsBzr,
 	vcsGit.vcs: vcsGit,
 	vcsHg.vcs:  vcsHg,
+	vcsSvn.vcs: vcsSvn,
 }
 
 // VCSFromDir builds a vcs command if the vcs detected from the
 // directory is supported
-func VCSFromDir(dir, srcRoot string) (*VCS, string, error) {
+func VCSFromDir(dir, srcRoot string) (VCS, string, error) {
 	vcscmd, reporoot, err := vcs.FromDir(dir, srcRoot)
 	if err != nil {
+		if v, root := externalFromDir(dir, srcRoot); v != nil {
+			return v, root, nil
+		}
 		return nil, "", err
 	}
 	vcsext := cmd[vcscmd]
@@ -89,7 +213,7 @@ func VCSFromDir(dir, srcRoot string) (*VCS, string, error) {
 
 // VCSForImportPath builds a vcs command if the vcs detected from
 // the import path is supported
-func VCSForImportPath(importPath string) (*VCS, *vcs.RepoRoot, error) {
+func VCSForImportPath(importPath string) (VCS, *vcs.RepoRoot, error) {
 	rr, err := vcs.RepoRootForImportPath(importPath, false)
 	if err != nil {
 		return nil, nil, err
@@ -101,12 +225,83 @@ func VCSForImportPath(importPath string) (*VCS, *vcs.RepoRoot, error) {
 	return vcs, rr, nil
 }
 
-func (v *VCS) identify(dir string) (string, error) {
+// spoolVCS returns the VCS of the sandbox repo in dir,
+// or nil if there is no such repo.
+func spoolVCS(dir string) VCS {
+	switch {
+	case exists(filepath.Join(dir, ".hg")):
+		return vcsHg
+	case exists(filepath.Join(dir, ".bzr")):
+		return vcsBzr
+	case exists(filepath.Join(dir, svnRemotes)):
+		return vcsSvn
+	case exists(filepath.Join(dir, "HEAD")) && exists(filepath.Join(dir, "objects")):
+		return vcsGit // created with git init --bare
+	}
+	return nil
+}
+
+// A Resolution maps the import path of a repo root to its
+// VCS and repo URL, so no network lookup is needed to find it.
+type Resolution struct {
+	ImportPath string // Import path of the repo root.
+	VCS        string // Name of the VCS command, e.g. "git".
+	Repo       string // Repo URL, including scheme.
+}
+
+// resolveStatic finds the entry in table whose ImportPath is
+// the longest prefix of importPath, and returns its VCS and
+// repo root. If no entry matches, it returns nil values.
+func resolveStatic(importPath string, table []Resolution) (VCS, *vcs.RepoRoot, error) {
+	var best *Resolution
+	for i, r := range table {
+		if !containsPathPrefix([]string{r.ImportPath}, importPath) {
+			continue
+		}
+		if best == nil || len(r.ImportPath) > len(best.ImportPath) {
+			best = &table[i]
+		}
+	}
+	if best == nil {
+		return nil, nil, nil
+	}
+	v := vcsByName(best.VCS)
+	if v == nil {
+		return nil, nil, fmt.Errorf("%s is unsupported: %s", best.VCS, importPath)
+	}
+	return v, &vcs.RepoRoot{Repo: best.Repo, Root: best.ImportPath}, nil
+}
+
+// vcsByName returns the built-in VCS whose command is
+// name, or else the external driver for name, or nil.
+func vcsByName(name string) VCS {
+	for _, v := range cmd {
+		if v.vcs.Cmd == name {
+			return v
+		}
+	}
+	if v := lookupExternal(name); v != nil {
+		return v
+	}
+	return nil
+}
+
+func (v *cmdVCS) name() string {
+	return v.vcs.Cmd
+}
+
+func (v *cmdVCS) identify(dir string) (string, error) {
+	if v.IdentifyFunc != nil {
+		return v.IdentifyFunc(v, dir)
+	}
 	out, err := v.runOutput(dir, v.IdentifyCmd)
 	return string(bytes.TrimSpace(out)), err
 }
 
-func (v *VCS) describe(dir, rev string) string {
+func (v *cmdVCS) describe(dir, rev string) string {
+	if v.DescribeFunc != nil {
+		return v.DescribeFunc(v, dir, rev)
+	}
 	out, err := v.runOutputVerboseOnly(dir, v.DescribeCmd, "rev", rev)
 	if err != nil {
 		return ""
@@ -114,39 +309,192 @@ func (v *VCS) describe(dir, rev string) string {
 	return string(bytes.TrimSpace(out))
 }
 
-func (v *VCS) isDirty(dir, rev string) bool {
+// remoteURL returns the URL the repo in dir was cloned
+// from, or "" if it can't be determined.
+func (v *cmdVCS) remoteURL(dir string) string {
+	out, err := v.runOutputVerboseOnly(dir, v.RemoteCmd)
+	if err != nil {
+		return ""
+	}
+	return string(bytes.TrimSpace(out))
+}
+
+func (v *cmdVCS) isDirty(dir, rev string) bool {
 	out, err := v.runOutput(dir, v.DiffCmd, "rev", rev)
 	return err != nil || len(out) != 0
 }
 
-func (v *VCS) create(dir string) error {
+func (v *cmdVCS) canSandbox() bool {
+	return v.CreateCmd != "" || v.CreateFunc != nil
+}
+
+func (v *cmdVCS) create(dir string) error {
+	if v.CreateFunc != nil {
+		return v.CreateFunc(v, dir)
+	}
 	return v.run(dir, v.CreateCmd)
 }
 
-func (v *VCS) link(dir, remote, url string) error {
+func (v *cmdVCS) link(dir, remote, url string) error {
 	if v.LinkFunc != nil {
 		return v.LinkFunc(dir, remote, url)
 	}
 	return v.run(dir, v.LinkCmd, "remote", remote, "url", url)
 }
 
-func (v *VCS) exists(dir, rev string) bool {
+func (v *cmdVCS) exists(dir, rev string) bool {
+	if v.ExistsFunc != nil {
+		return v.ExistsFunc(v, dir, rev)
+	}
 	err := v.runVerboseOnly(dir, v.ExistsCmd, "rev", rev)
 	return err == nil
 }
 
-func (v *VCS) fetch(dir, remote string) error {
+// fetch fetches from remote into the sandbox repo in dir.
+// Most VCSes fetch everything and ignore rev.
+func (v *cmdVCS) fetch(dir, remote, rev string) error {
+	if v.FetchFunc != nil {
+		return v.FetchFunc(v, dir, remote, rev)
+	}
 	return v.run(dir, v.FetchCmd, "remote", remote)
 }
 
+func (v *cmdVCS) checkoutDirs(dir, rev, repo string, dirs []string) error {
+	if v.CheckoutDirsFunc == nil {
+		return errNoSparse
+	}
+	return v.CheckoutDirsFunc(v, dir, rev, repo, dirs)
+}
+
+func (v *cmdVCS) clone(dir, url string) error {
+	return v.vcs.Create(dir, url)
+}
+
+func (v *cmdVCS) download(dir string) error {
+	if v.DownloadCmd != "" {
+		return v.run(dir, v.DownloadCmd)
+	}
+	return v.vcs.Download(dir)
+}
+
 // RevSync checks out the revision given by rev in dir.
 // The dir must exist and rev must be a valid revision.
-func (v *VCS) RevSync(dir, rev string) error {
+func (v *cmdVCS) RevSync(dir, rev string) error {
+	if v.RevSyncFunc != nil {
+		return v.RevSyncFunc(v, dir, rev)
+	}
+	if v.RevSyncCmd != "" {
+		return v.run(dir, v.RevSyncCmd, "rev", rev)
+	}
 	return v.run(dir, v.vcs.TagSyncCmd, "tag", rev)
 }
 
-func (v *VCS) checkout(dir, rev, repo string) error {
-	return v.run(dir, v.CheckoutCmd, "rev", rev, "repo", repo)
+// unpushed reports whether the working copy in dir has
+// commits that are in no remote. If it can't tell, it
+// reports true.
+func (v *cmdVCS) unpushed(dir string) bool {
+	if v.UnpushedCmd == "" {
+		return false
+	}
+	out, err := v.runOutput(dir, v.UnpushedCmd)
+	return err != nil || len(out) != 0
+}
+
+func (v *cmdVCS) branch(dir string) string {
+	if v.BranchCmd == "" || v.BranchSyncCmd == "" {
+		return ""
+	}
+	out, err := v.runOutputVerboseOnly(dir, v.BranchCmd)
+	if err != nil {
+		return ""
+	}
+	return string(bytes.TrimSpace(out))
+}
+
+func (v *cmdVCS) branchSync(dir, branch string) error {
+	return v.run(dir, v.BranchSyncCmd, "branch", branch)
+}
+
+func (v *cmdVCS) canStash() bool {
+	return v.StashCmd != "" && v.UnstashCmd != ""
+}
+
+func (v *cmdVCS) stash(dir string) error {
+	if v.StashCmd == "" {
+		return errNoStash
+	}
+	return v.run(dir, v.StashCmd)
+}
+
+func (v *cmdVCS) unstash(dir string) error {
+	if v.UnstashCmd == "" {
+		return errNoStash
+	}
+	return v.run(dir, v.UnstashCmd)
+}
+
+// resolveRev returns the ID of the revision named ver, which
+// may be a revision ID, tag, or branch of remote, in the
+// sandbox repo dir. A full revision ID is returned as is, to
+// be fetched on its own. Otherwise, unless offline, it first
+// fetches all of remote's branches and tags.
+func (v *cmdVCS) resolveRev(dir, remote, ver string) (string, error) {
+	if v.ResolveCmd == "" {
+		return "", errNoResolve
+	}
+	if isRevID(ver) {
+		return ver, nil
+	}
+	if !offline && v.FetchCmd != "" {
+		if err := v.run(dir, v.FetchCmd, "remote", remote); err != nil {
+			return "", err
+		}
+	}
+	for _, name := range []string{remote + "/" + ver, ver} {
+		out, err := v.runOutputVerboseOnly(dir, v.ResolveCmd, "ver", name)
+		if id := string(bytes.TrimSpace(out)); err == nil && id != "" {
+			return id, nil
+		}
+	}
+	return "", errors.New("unknown revision " + ver)
+}
+
+// isRevID reports whether s looks like a full git or hg
+// revision ID: 40 lower-case hex digits, or 64 for SHA-256
+// git repos.
+func isRevID(s string) bool {
+	if len(s) != 40 && len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// isAncestor reports whether revision a is an ancestor of,
+// or the same as, revision b in the sandbox repo dir.
+func (v *cmdVCS) isAncestor(dir, a, b string) (bool, error) {
+	if v.IsAncestorFunc != nil {
+		return v.IsAncestorFunc(v, dir, a, b)
+	}
+	if v.MergeBaseCmd == "" {
+		return false, errNoAncestry
+	}
+	out, err := v.runOutput(dir, v.MergeBaseCmd, "a", a, "b", b)
+	if err != nil {
+		return false, err
+	}
+	return string(bytes.TrimSpace(out)) == a, nil
+}
+
+func (v *cmdVCS) checkout(dir, rev, repo string) error {
+	if v.CheckoutFunc != nil {
+		return v.CheckoutFunc(v, dir, rev, repo)
+	}
+	return v.run(dir, v.CheckoutCmd, "rev", rev, "repo", repo, "dir", dir)
 }
 
 // run runs the command line cmd in the given directory.
@@ -156,29 +504,43 @@ func (v *VCS) checkout(dir, rev, repo string) error {
 // If an error occurs, run prints the command line and the
 // command's combined stdout+stderr to standard error.
 // Otherwise run discards the command's output.
-func (v *VCS) run(dir string, cmdline string, kv ...string) error {
+func (v *cmdVCS) run(dir string, cmdline string, kv ...string) error {
 	_, err := v.run1(dir, cmdline, kv, true)
 	return err
 }
 
 // runVerboseOnly is like run but only generates error output to standard error in verbose mode.
-func (v *VCS) runVerboseOnly(dir string, cmdline string, kv ...string) error {
+func (v *cmdVCS) runVerboseOnly(dir string, cmdline string, kv ...string) error {
 	_, err := v.run1(dir, cmdline, kv, false)
 	return err
 }
 
 // runOutput is like run but returns the output of the command.
-func (v *VCS) runOutput(dir string, cmdline string, kv ...string) ([]byte, error) {
+func (v *cmdVCS) runOutput(dir string, cmdline string, kv ...string) ([]byte, error) {
 	return v.run1(dir, cmdline, kv, true)
 }
 
 // runOutputVerboseOnly is like runOutput but only generates error output to standard error in verbose mode.
-func (v *VCS) runOutputVerboseOnly(dir string, cmdline string, kv ...string) ([]byte, error) {
+func (v *cmdVCS) runOutputVerboseOnly(dir string, cmdline string, kv ...string) ([]byte, error) {
 	return v.run1(dir, cmdline, kv, false)
 }
 
 // run1 is the generalized implementation of run and runOutput.
-func (v *VCS) run1(dir string, cmdline string, kv []string, verbose bool) ([]byte, error) {
+func (v *cmdVCS) run1(dir string, cmdline string, kv []string, verbose bool) ([]byte, error) {
+	return v.exec(dir, expandFields(cmdline, kv), verbose)
+}
+
+// runArgs is like run, but appends extra to the command line
+// after expansion, so they are neither split nor expanded.
+func (v *cmdVCS) runArgs(dir string, cmdline string, kv []string, extra ...string) error {
+	_, err := v.exec(dir, append(expandFields(cmdline, kv), extra...), true)
+	return err
+}
+
+// expandFields splits cmdline into arguments, then expands
+// instances of {key} in each argument into value, where kv
+// is a list of key, value pairs.
+func expandFields(cmdline string, kv []string) []string {
 	m := make(map[string]string)
 	for i := 0; i < len(kv); i += 2 {
 		m[kv[i]] = kv[i+1]
@@ -187,7 +549,11 @@ func (v *VCS) run1(dir string, cmdline string, kv []string, verbose bool) ([]byt
 	for i, arg := range args {
 		args[i] = expand(m, arg)
 	}
+	return args
+}
 
+// exec runs the VCS command with args in dir.
+func (v *cmdVCS) exec(dir string, args []string, verbose bool) ([]byte, error) {
 	_, err := exec.LookPath(v.vcs.Cmd)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "goderp: missing %s command.\n", v.vcs.Name)
@@ -199,10 +565,10 @@ func (v *VCS) run1(dir string, cmdline string, kv []string, verbose bool) ([]byt
 	var buf bytes.Buffer
 	cmd.Stdout = &buf
 	cmd.Stderr = &buf
-	err = cmd.Run()
+	err = runCmd(cmd)
 	out := buf.Bytes()
 	if err != nil {
-		if verbose {
+		if verbose || verboseOutput {
 			fmt.Fprintf(os.Stderr, "# cd %s; %s %s\n", dir, v.vcs.Cmd, strings.Join(args, " "))
 			os.Stderr.Write(out)
 		}
@@ -219,17 +585,92 @@ func expand(m map[string]string, s string) string {
 }
 
 // Mercurial has no command equivalent to git remote add.
-// We handle it as a special case in process.
+// We handle it as a special case in process, setting the
+// remote in the [paths] section of hgrc. Any other entries
+// for the same remote, as left by earlier versions of
+// hgLink, are removed.
 func hgLink(dir, remote, url string) error {
 	hgdir := filepath.Join(dir, ".hg")
 	if err := os.MkdirAll(hgdir, 0777); err != nil {
 		return err
 	}
 	path := filepath.Join(hgdir, "hgrc")
-	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	b, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	entry := remote + " = " + url
+	var lines []string
+	if len(b) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	}
+	var out []string
+	section, done := "", false
+	for _, line := range lines {
+		t := strings.TrimSpace(line)
+		if strings.HasPrefix(t, "[") && strings.HasSuffix(t, "]") {
+			if section == "paths" && !done {
+				out = append(out, entry)
+				done = true
+			}
+			section = t[1 : len(t)-1]
+		} else if kv := strings.SplitN(t, "=", 2); section == "paths" && len(kv) == 2 && strings.TrimSpace(kv[0]) == remote {
+			if done {
+				continue
+			}
+			line, done = entry, true
+		}
+		out = append(out, line)
+	}
+	if !done {
+		if section != "paths" {
+			out = append(out, "[paths]")
+		}
+		out = append(out, entry)
+	}
+	return ioutil.WriteFile(path, []byte(strings.Join(out, "\n")+"\n"), 0666)
+}
+
+// Bazaar has no named remotes. LinkCmd records the URL
+// in the branch config, and we look it up here.
+func bzrFetch(v *cmdVCS, dir, remote, rev string) error {
+	out, err := v.runOutput(dir, "config deppy_remote_{remote}", "remote", remote)
 	if err != nil {
 		return err
 	}
-	fmt.Fprintf(f, "[paths]\n%s = %s\n", remote, url)
-	return f.Close()
+	url := string(bytes.TrimSpace(out))
+	if url == "" {
+		return fmt.Errorf("no remote %s", remote)
+	}
+	return v.run(dir, "pull --quiet --overwrite {url}", "url", url)
+}
+
+// bzrDescribe describes rev like git describe --tags,
+// using the most recent tag at or before rev on the
+// mainline. It returns the revno if there is no such tag.
+func bzrDescribe(v *cmdVCS, dir, rev string) string {
+	out, err := v.runOutputVerboseOnly(dir, "revno -r revid:{rev}", "rev", rev)
+	if err != nil {
+		return ""
+	}
+	revno := string(bytes.TrimSpace(out))
+	out, err = v.runOutputVerboseOnly(dir, "tags --sort time -r ..revid:{rev}", "rev", rev)
+	if err != nil {
+		return revno
+	}
+	lines := strings.Split(string(bytes.TrimSpace(out)), "\n")
+	f := strings.Fields(lines[len(lines)-1])
+	if len(f) != 2 {
+		return revno
+	}
+	tag, tagRevno := f[0], f[1]
+	n, err1 := strconv.Atoi(revno)
+	m, err2 := strconv.Atoi(tagRevno)
+	switch {
+	case tagRevno == revno:
+		return tag
+	case err1 == nil && err2 == nil:
+		return fmt.Sprintf("%s-%d", tag, n-m)
+	}
+	return tag + "-" + revno
 }
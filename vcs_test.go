@@ -0,0 +1,292 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestResolveStatic(t *testing.T) {
+	table := []Resolution{
+		{"example.com/x", "git", "https://git.example.com/x.git"},
+		{"example.com/x/y", "hg", "https://hg.example.com/y"},
+		{"example.com/z", "cvs", "cvs://example.com/z"},
+	}
+	var cases = []struct {
+		importPath string
+		vcs        VCS
+		root       string
+		repo       string
+		werr       bool
+	}{
+		{"example.com/x", vcsGit, "example.com/x", "https://git.example.com/x.git", false},
+		{"example.com/x/p", vcsGit, "example.com/x", "https://git.example.com/x.git", false},
+		{"example.com/x/y/p", vcsHg, "example.com/x/y", "https://hg.example.com/y", false},
+		{"example.com/xy", nil, "", "", false},
+		{"example.com/z/p", nil, "", "", true},
+	}
+	for _, test := range cases {
+		v, rr, err := resolveStatic(test.importPath, table)
+		if g := err != nil; g != test.werr {
+			t.Errorf("resolveStatic(%s) err = %v want %v", test.importPath, err, test.werr)
+			continue
+		}
+		if v != test.vcs {
+			t.Errorf("resolveStatic(%s) vcs = %v want %v", test.importPath, v, test.vcs)
+		}
+		if rr == nil {
+			if test.root != "" {
+				t.Errorf("resolveStatic(%s) = nil want root %s", test.importPath, test.root)
+			}
+			continue
+		}
+		if rr.Root != test.root || rr.Repo != test.repo {
+			t.Errorf("resolveStatic(%s) = %s, %s want %s, %s", test.importPath, rr.Root, rr.Repo, test.root, test.repo)
+		}
+	}
+}
+
+func TestSandboxGit(t *testing.T) {
+	testSandbox(t, vcsGit, [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"commit", "-q", "-m", "deppy"},
+		{"tag", "v1"},
+	}, "v1")
+}
+
+func TestSandboxBzr(t *testing.T) {
+	testSandbox(t, vcsBzr, [][]string{
+		{"init", "-q"},
+		{"add", "-q", "."},
+		{"commit", "-q", "-m", "deppy"},
+		{"tag", "-q", "v1"},
+	}, "v1")
+}
+
+// testSandbox makes a local repo using the given commands,
+// then checks that sandbox can check out its revision and
+// that describe finds the given tag.
+func testSandbox(t *testing.T, v *cmdVCS, cmds [][]string, tag string) {
+	if _, err := exec.LookPath(v.vcs.Cmd); err != nil {
+		t.Skipf("%s not installed", v.vcs.Cmd)
+	}
+	dir, err := ioutil.TempDir("", "deppyvcs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	remote := filepath.Join(dir, "remote")
+	err = writeFile(filepath.Join(remote, "a.go"), pkg("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range cmds {
+		run(t, remote, v.vcs.Cmd, args...)
+	}
+	checkSandbox(t, v, dir, remote, remote, tag)
+}
+
+func TestSandboxSvn(t *testing.T) {
+	for _, name := range []string{"svn", "svnadmin"} {
+		if _, err := exec.LookPath(name); err != nil {
+			t.Skipf("%s not installed", name)
+		}
+	}
+	dir, err := ioutil.TempDir("", "deppyvcs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := filepath.Join(dir, "repo")
+	run(t, dir, "svnadmin", "create", repo)
+	url := "file://" + filepath.ToSlash(repo)
+	wc := filepath.Join(dir, "wc")
+	run(t, dir, "svn", "checkout", "-q", url, wc)
+	err = writeFile(filepath.Join(wc, "a.go"), pkg("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	run(t, wc, "svn", "add", "-q", "a.go")
+	run(t, wc, "svn", "commit", "-q", "-m", "deppy")
+	run(t, wc, "svn", "update", "-q")
+	checkSandbox(t, vcsSvn, dir, wc, url, "r1")
+}
+
+// checkSandbox checks that describe finds the given tag
+// for the working copy in wc, and that sandbox can check
+// out its revision from url into a spool in dir.
+func checkSandbox(t *testing.T, v *cmdVCS, dir, wc, url, tag string) {
+	defer func(s string) { spool = s }(spool)
+	spool = filepath.Join(dir, "spool")
+
+	rev, err := v.identify(wc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := v.describe(wc, rev); g != tag {
+		t.Errorf("describe = %q want %q", g, tag)
+	}
+
+	d := Dependency{
+		ImportPath: "example.com/r",
+		Rev:        rev,
+		repoRoot:   &vcs.RepoRoot{Root: "example.com/r", Repo: url},
+		vcs:        v,
+	}
+	gopath, err := sandbox(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadFile(filepath.Join(gopath, "src", "example.com", "r", "a.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := string(body); g != pkg("a") {
+		t.Errorf("a.go = %q want %q", g, pkg("a"))
+	}
+}
+
+func TestPairs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deppypairs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "pairs")
+
+	if v, err := lookupPair(name, "a"); v != "" || err != nil {
+		t.Errorf("lookupPair(missing) = %q, %v want empty", v, err)
+	}
+	for _, kv := range [][2]string{{"a", "x"}, {"b", "y z"}, {"a", "w"}} {
+		if err := setPair(name, kv[0], kv[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for k, want := range map[string]string{"a": "w", "b": "y z", "c": ""} {
+		v, err := lookupPair(name, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != want {
+			t.Errorf("lookupPair(%q) = %q want %q", k, v, want)
+		}
+	}
+}
+
+func TestSandboxHg(t *testing.T) {
+	testSandbox(t, vcsHg, [][]string{
+		{"init"},
+		{"add", "-q"},
+		{"commit", "-q", "-u", "deppy", "-m", "deppy"},
+		{"tag", "-u", "deppy", "v1"},
+	}, "v1-1")
+}
+
+func TestHgLink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deppyhg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	hgrc := filepath.Join(dir, ".hg", "hgrc")
+	err = writeFile(hgrc, "[ui]\nx = y\n[paths]\nmain = old\n[paths]\nmain = older\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kv := range [][2]string{{"main", "a"}, {"fast", "b"}, {"main", "c"}} {
+		if err := hgLink(dir, kv[0], kv[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	body, err := ioutil.ReadFile(hgrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[ui]\nx = y\n[paths]\nmain = c\nfast = b\n[paths]\n"
+	if g := string(body); g != want {
+		t.Errorf("hgrc = %q want %q", g, want)
+	}
+}
+
+func TestGitFetch(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir, err := ioutil.TempDir("", "deppygit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	remote := filepath.Join(dir, "remote")
+	if err := writeFile(filepath.Join(remote, "a.go"), pkg("a")); err != nil {
+		t.Fatal(err)
+	}
+	run(t, remote, "git", "init", "-q")
+	run(t, remote, "git", "add", ".")
+	run(t, remote, "git", "commit", "-q", "-m", "one")
+	rev, err := vcsGit.identify(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run(t, remote, "git", "commit", "-q", "--allow-empty", "-m", "two")
+	head, err := vcsGit.identify(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := filepath.Join(dir, "repo")
+	if err := os.MkdirAll(repo, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := vcsGit.create(repo); err != nil {
+		t.Fatal(err)
+	}
+	if err := vcsGit.link(repo, "main", remote); err != nil {
+		t.Fatal(err)
+	}
+	if err := vcsGit.fetch(repo, "main", rev); err != nil {
+		t.Fatal(err)
+	}
+	if !vcsGit.exists(repo, rev) {
+		t.Errorf("rev %s not fetched", rev)
+	}
+	if vcsGit.exists(repo, head) {
+		t.Errorf("head %s fetched, want only %s", head, rev)
+	}
+	run(t, repo, "git", "rev-parse", "--verify", "refs/deppy/main/"+rev)
+}
+
+func TestSvnOffline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deppysvn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(b bool) { offline = b }(offline)
+	offline = true
+
+	const rev = "0f1e2d3c-uuid@5"
+	if err := svnCreate(vcsSvn, dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := svnLink(dir, "main", "https://svn.example.com/r"); err != nil {
+		t.Fatal(err)
+	}
+	if err := svnFetch(vcsSvn, dir, "main", rev); err == nil {
+		t.Error("svnFetch from a remote URL succeeded offline")
+	}
+	if err := setPair(filepath.Join(dir, svnRevs), rev, "https://svn.example.com/r"); err != nil {
+		t.Fatal(err)
+	}
+	if err := svnCheckout(vcsSvn, dir, rev, dir); err == nil {
+		t.Error("svnCheckout from a remote URL succeeded offline")
+	}
+}